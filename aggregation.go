@@ -9,17 +9,48 @@ import (
 
 func (e *ESql) convertAggregation(sel sqlparser.Select) (selectedColNames []string, dsl string, err error) {
 	if len(sel.GroupBy) == 0 && sel.Having != nil {
-		err = fmt.Errorf(`esql: HAVING used without GROUP BY`)
+		err = errorf(ErrSyntax, sqlparser.String(sel.Having.Expr), `esql: HAVING used without GROUP BY`)
 		return nil, "", err
 	}
 
-	aggMaps := make(map[string]string)
-	dslGroupBy, err := e.convertGroupBy(sel.GroupBy)
+	aggMaps := make(map[string]aggregation)
+	groupBy, selectExprs := sel.GroupBy, sel.SelectExprs
+	geohashGrid, byGeohash := geohashGridGroupBy(groupBy)
+	var geohashTag, geohashBody string
+	if byGeohash {
+		if len(groupBy) != 1 {
+			err = errorf(ErrUnsupported, sqlparser.String(groupBy), `esql: GROUP BY GEOHASH_GRID w/ other columns not supported`)
+			return nil, "", err
+		}
+		geohashTag, geohashBody, err = e.convertGeoAggregation(*geohashGrid)
+		if err != nil {
+			return nil, "", err
+		}
+		// the grouped cell is the key of a bucket rather than an aggregation
+		selectExprs = nil
+		for _, selectExpr := range sel.SelectExprs {
+			if aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr); !ok || sqlparser.String(aliasedExpr.Expr) != sqlparser.String(geohashGrid) {
+				selectExprs = append(selectExprs, selectExpr)
+			}
+		}
+		groupBy = nil
+	}
+	groupingSets, err := e.convertGroupBy(groupBy)
+	if err != nil {
+		return nil, "", err
+	}
+	e.aggPath, err = e.nestedGroupPath(groupingSets)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// GROUPING(colName) depends on the grouping set of a bucket, so it is not part of aggMaps
+	selectExprs, groupings, err := e.convertGroupingFuncs(selectExprs, groupingSets)
 	if err != nil {
 		return nil, "", err
 	}
 
-	selectedColNames, err = e.convertSelectExpr(sel.SelectExprs, aggMaps)
+	selectedColNames, err = e.convertSelectExpr(selectExprs, aggMaps)
 	if err != nil {
 		return nil, "", err
 	}
@@ -34,10 +65,22 @@ func (e *ESql) convertAggregation(sel sqlparser.Select) (selectedColNames []stri
 		return nil, "", err
 	}
 
+	// COUNT(*) alone has no aggregation in the dsl but is still answered by aggregating
+	aggregated := byGeohash || len(groupingSets) > 0 || len(aggMaps) > 0
+	if e.request != nil {
+		e.request.ungrouped = aggregated && !byGeohash && len(groupingSets) == 0
+	}
+	// GEO_DISTANCE sorts documents, which an aggregation does not return
+	for _, orderExpr := range sel.OrderBy {
+		if aggregated && isGeoDistanceFunc(orderExpr.Expr) {
+			err = errorf(ErrUnsupported, sqlparser.String(orderExpr.Expr), `esql: ORDER BY GEO_DISTANCE in aggregation not supported`)
+			return nil, "", err
+		}
+	}
 	var aggs []string
-	for tag, body := range aggMaps {
+	for tag, agg := range aggMaps {
 		if tag != "_count" {
-			aggs = append(aggs, fmt.Sprintf(`"%v": {%v}`, tag, body))
+			aggs = append(aggs, fmt.Sprintf(`"%v": {%v}`, tag, agg.body))
 		}
 	}
 	if dslOrderBy != "" {
@@ -46,19 +89,59 @@ func (e *ESql) convertAggregation(sel sqlparser.Select) (selectedColNames []stri
 	if dslHaving != "" {
 		aggs = append(aggs, fmt.Sprintf(`"having": {%v}`, dslHaving))
 	}
-	if len(aggs) > 0 {
-		dsl = fmt.Sprintf(`{%v}`, strings.Join(aggs, ","))
+	// buckets of a geohash_grid aggregation are keyed by the cell, which is named by meta
+	if byGeohash {
+		dsl = fmt.Sprintf(`"groupby": {"meta": {"key": "%v"}, %v}`, geohashTag, geohashBody)
+		if len(aggs) > 0 {
+			dsl = fmt.Sprintf(`"groupby": {"meta": {"key": "%v"}, %v, "aggs": {%v}}`, geohashTag, geohashBody, strings.Join(aggs, ","))
+		}
+		return selectedColNames, fmt.Sprintf(`{%v}`, dsl), nil
+	}
+	if len(groupingSets) == 0 {
+		if len(aggs) > 0 {
+			dsl = fmt.Sprintf(`{%v}`, strings.Join(aggs, ","))
+		}
+		return selectedColNames, dsl, nil
 	}
 
-	if dslGroupBy != "" && len(aggMaps) == 0 {
-		dsl = fmt.Sprintf(`{"groupby": {%v}}`, dslGroupBy)
-	} else if dslGroupBy != "" && len(aggMaps) != 0 {
-		dsl = fmt.Sprintf(`{"groupby": {%v, "aggs": %v}}`, dslGroupBy, dsl)
+	// each grouping set gets its own composite aggregation, the first one keeps the tag "groupby"
+	var groupBySlice []string
+	for i, groupingSet := range groupingSets {
+		tag := "groupby"
+		if i > 0 {
+			tag = fmt.Sprintf(`groupby_%v`, i)
+		}
+		setAggs := append([]string{}, aggs...)
+		for _, groupingTag := range groupings.tags {
+			grouping := 1
+			for _, colNameStr := range groupingSet {
+				if colNameStr == groupings.colNames[groupingTag] {
+					grouping = 0
+				}
+			}
+			body := fmt.Sprintf(`"bucket_script": {"buckets_path": {"_count": "_count"}, "script": "return %v;"}`, grouping)
+			setAggs = append(setAggs, fmt.Sprintf(`"%v": {%v}`, groupingTag, body))
+		}
+		dslGroupBy, err := e.convertGroupingSet(groupingSet)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(setAggs) == 0 {
+			groupBySlice = append(groupBySlice, fmt.Sprintf(`"%v": {%v}`, tag, dslGroupBy))
+		} else {
+			groupBySlice = append(groupBySlice, fmt.Sprintf(`"%v": {%v, "aggs": {%v}}`, tag, dslGroupBy, strings.Join(setAggs, ",")))
+		}
+	}
+	dsl = strings.Join(groupBySlice, ",")
+	// buckets of nested columns are in a nested aggregation
+	if e.aggPath != "" {
+		dsl = fmt.Sprintf(`"nested": {"nested": {"path": "%v"}, "aggs": {%v}}`, e.aggPath, dsl)
 	}
+	dsl = fmt.Sprintf(`{%v}`, dsl)
 	return selectedColNames, dsl, nil
 }
 
-func (e *ESql) convertOrderBy(orderBy sqlparser.OrderBy, aggMaps map[string]string) (dsl string, err error) {
+func (e *ESql) convertOrderBy(orderBy sqlparser.OrderBy, aggMaps map[string]aggregation) (dsl string, err error) {
 	if orderBy == nil {
 		return "", nil
 	}
@@ -66,18 +149,26 @@ func (e *ESql) convertOrderBy(orderBy sqlparser.OrderBy, aggMaps map[string]stri
 	for _, orderExpr := range orderBy {
 		switch expr := orderExpr.Expr.(type) {
 		case *sqlparser.FuncExpr:
-			tag, body, err := e.convertFuncExpr(*expr)
-			if err != nil {
-				return "", err
+			// GEO_DISTANCE sorts documents rather than buckets, it is rejected if the query aggregates
+			if isGeoDistanceFunc(expr) {
+				continue
 			}
-			if _, exist := aggMaps[tag]; !exist {
-				aggMaps[tag] = body
+			tag, selected := selectedAggregation(expr, aggMaps)
+			if !selected {
+				var agg aggregation
+				tag, agg, err = e.convertFuncExpr(*expr)
+				if err != nil {
+					return "", err
+				}
+				if _, exist := aggMaps[tag]; !exist {
+					aggMaps[tag] = agg
+				}
 			}
-			dslOrder := fmt.Sprintf(`{"%v": {"order": "%v"}}`, tag, orderExpr.Direction)
+			dslOrder := fmt.Sprintf(`{"%v": {"order": "%v"}}`, aggMaps[tag].bucketPath(tag), orderExpr.Direction)
 			dslOrderSlice = append(dslOrderSlice, dslOrder)
 		case *sqlparser.ColName:
 		default:
-			err = fmt.Errorf(`esql: %T not supported in ORDER BY`, expr)
+			err = errorf(ErrUnsupported, sqlparser.String(expr), `esql: %T not supported in ORDER BY`, expr)
 			return "", err
 		}
 	}
@@ -93,7 +184,7 @@ func (e *ESql) convertGroupConcatExpr(concatExpr sqlparser.GroupConcatExpr) (tag
 	for _, selExpr := range concatExpr.Exprs {
 		colName, ok := selExpr.(*sqlparser.AliasedExpr).Expr.(*sqlparser.ColName)
 		if !ok {
-			err := fmt.Errorf(`esql: fail to parse group concat`)
+			err := errorf(ErrSyntax, sqlparser.String(selExpr), `esql: fail to parse group concat`)
 			return "", "", err
 		}
 		colNameStr, err := e.convertColName(colName)
@@ -101,7 +192,11 @@ func (e *ESql) convertGroupConcatExpr(concatExpr sqlparser.GroupConcatExpr) (tag
 			return "", "", err
 		}
 		colNameStrSlice = append(colNameStrSlice, colNameStr)
-		unitStrSlice = append(unitStrSlice, fmt.Sprintf(`doc['%v'].value`, colNameStr))
+		field, err := e.scriptField(colNameStr)
+		if err != nil {
+			return "", "", err
+		}
+		unitStrSlice = append(unitStrSlice, fmt.Sprintf(`doc['%v'].value`, field))
 	}
 
 	sep := concatExpr.Separator[12 : len(concatExpr.Separator)-1]
@@ -121,14 +216,14 @@ func (e *ESql) convertGroupConcatExpr(concatExpr sqlparser.GroupConcatExpr) (tag
 	return tag, body, nil
 }
 
-func (e *ESql) convertSelectExpr(exprs sqlparser.SelectExprs, aggMaps map[string]string) (colNameSlice []string, err error) {
+func (e *ESql) convertSelectExpr(exprs sqlparser.SelectExprs, aggMaps map[string]aggregation) (colNameSlice []string, err error) {
 	for _, selectExpr := range exprs {
 		if sqlparser.String(selectExpr) == "*" {
 			return nil, nil
 		}
 		aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr)
 		if !ok {
-			err = fmt.Errorf(`esql: %T not supported in SELECT`, selectExpr)
+			err = errorf(ErrUnsupported, sqlparser.String(selectExpr), `esql: %T not supported in SELECT`, selectExpr)
 			return nil, err
 		}
 		aggTagStr := sqlparser.String(aliasedExpr.As)
@@ -137,15 +232,26 @@ func (e *ESql) convertSelectExpr(exprs sqlparser.SelectExprs, aggMaps map[string
 		}
 		switch expr := aliasedExpr.Expr.(type) {
 		case *sqlparser.FuncExpr:
-			tag, body, err := e.convertFuncExpr(*expr)
+			if isScriptFunc(expr) {
+				err = e.convertSelectScriptExpr(expr, aggTagStr, aggMaps)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			tag, agg, err := e.convertFuncExpr(*expr)
 			if err != nil {
 				return nil, err
 			}
 			if aggTagStr == "" {
 				aggTagStr = tag
 			}
+			// COUNT(*) is the doc count of a bucket, which is never aggregated again
+			if tag != "_count" {
+				agg.expr = sqlparser.String(expr)
+			}
 			if _, exist := aggMaps[aggTagStr]; !exist {
-				aggMaps[aggTagStr] = body
+				aggMaps[aggTagStr] = agg
 			}
 		case *sqlparser.ColName:
 			lhsStr, err := e.convertColName(expr)
@@ -162,86 +268,332 @@ func (e *ESql) convertSelectExpr(exprs sqlparser.SelectExprs, aggMaps map[string
 				aggTagStr = tag
 			}
 			if _, exist := aggMaps[aggTagStr]; !exist {
-				aggMaps[aggTagStr] = body
+				aggMaps[aggTagStr] = aggregation{body: body}
 			}
-		case *sqlparser.BinaryExpr, *sqlparser.UnaryExpr, *sqlparser.ParenExpr:
-			script, err := e.convertToScript(expr, aggMaps)
+		case *sqlparser.BinaryExpr, *sqlparser.UnaryExpr, *sqlparser.ParenExpr, *sqlparser.CaseExpr, *sqlparser.ConvertExpr, *sqlparser.SubstrExpr:
+			err = e.convertSelectScriptExpr(expr, aggTagStr, aggMaps)
 			if err != nil {
 				return nil, err
 			}
-			if aggTagStr == "" {
-				aggTagStr = fmt.Sprintf(`expr_%v`, len(aggMaps))
-			}
-			var bucketPathSlice []string
-			for tag := range aggMaps {
-				bucketPathSlice = append(bucketPathSlice, fmt.Sprintf(`"%v": "%v"`, tag, tag))
-			}
-			bucketPathStr := strings.Join(bucketPathSlice, ",")
-			body := fmt.Sprintf(`"bucket_script": {"buckets_path": {%v}, "script": "return %v;"}`, bucketPathStr, script)
-			if _, exist := aggMaps[aggTagStr]; !exist {
-				aggMaps[aggTagStr] = body
-			}
 		default:
-			err = fmt.Errorf(`esql: %T not supported in SELECT`, expr)
+			err = errorf(ErrUnsupported, sqlparser.String(expr), `esql: %T not supported in SELECT`, expr)
 			return nil, err
 		}
 	}
 	return colNameSlice, nil
 }
 
-func (e *ESql) convertGroupBy(expr sqlparser.GroupBy) (dsl string, err error) {
+// convertSelectScriptExpr adds a bucket_script aggregation that evaluates expr on each bucket
+func (e *ESql) convertSelectScriptExpr(expr sqlparser.Expr, aggTagStr string, aggMaps map[string]aggregation) error {
+	script, err := e.convertToScript(expr, aggMaps)
+	if err != nil {
+		return err
+	}
+	if aggTagStr == "" {
+		aggTagStr = fmt.Sprintf(`expr_%v`, len(aggMaps))
+	}
+	body := fmt.Sprintf(`"bucket_script": {"buckets_path": {%v}, "script": "return %v;"}`, bucketPaths(aggMaps), script)
+	if _, exist := aggMaps[aggTagStr]; !exist {
+		aggMaps[aggTagStr] = aggregation{body: body}
+	}
+	return nil
+}
+
+// convertGroupBy returns the grouping sets of GROUP BY. a plain GROUP BY has exactly 1 grouping set,
+// ROLLUP, CUBE and GROUPING SETS add one grouping set for each level of subtotals
+func (e *ESql) convertGroupBy(expr sqlparser.GroupBy) (groupingSets [][]string, err error) {
 	if expr == nil {
-		return "", nil
+		return nil, nil
 	}
-	var groupByStrSlice []string
-	colNameSet := make(map[string]int)
+	groupingSets = [][]string{nil}
 	for _, groupByExpr := range expr {
+		var itemSets [][]string
 		switch groupByItem := groupByExpr.(type) {
 		case *sqlparser.ColName:
 			colNameStr, err := e.convertColName(groupByItem)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
-			if _, exist := colNameSet[colNameStr]; !exist {
-				colNameSet[colNameStr] = 1
-				groupByStr := fmt.Sprintf(`{"group_%v": {"terms": {"field": "%v", "missing_bucket": true}}}`, colNameStr, colNameStr)
-				groupByStrSlice = append(groupByStrSlice, groupByStr)
+			itemSets = [][]string{{colNameStr}}
+		case *sqlparser.FuncExpr:
+			itemSets, err = e.convertGroupingSetFunc(groupByItem)
+			if err != nil {
+				return nil, err
 			}
 		default:
-			err = fmt.Errorf(`esql: GROUP BY %T not supported`, groupByExpr)
+			err = errorf(ErrUnsupported, sqlparser.String(groupByExpr), `esql: GROUP BY %T not supported`, groupByExpr)
+			return nil, err
+		}
+		groupingSets = crossGroupingSets(groupingSets, itemSets)
+	}
+	return groupingSets, nil
+}
+
+func (e *ESql) convertGroupingSetFunc(funcExpr *sqlparser.FuncExpr) (groupingSets [][]string, err error) {
+	funcName := strings.ToLower(funcExpr.Name.String())
+	switch funcName {
+	case "rollup", "cube":
+		var colNameSlice []string
+		for _, expr := range funcExpr.Exprs {
+			colNameStr, err := e.convertGroupingSetColName(expr)
+			if err != nil {
+				return nil, err
+			}
+			colNameSlice = append(colNameSlice, colNameStr)
+		}
+		if len(colNameSlice) == 0 {
+			err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: %v requires at least 1 column`, funcName)
+			return nil, err
+		}
+		if funcName == "rollup" {
+			// ROLLUP(a, b) -> (a, b), (a), ()
+			for i := len(colNameSlice); i >= 0; i-- {
+				groupingSets = append(groupingSets, colNameSlice[:i])
+			}
+			return groupingSets, nil
+		}
+		// CUBE(a, b) -> (a, b), (a), (b), ()
+		n := len(colNameSlice)
+		for mask := 1<<uint(n) - 1; mask >= 0; mask-- {
+			var groupingSet []string
+			for i, colNameStr := range colNameSlice {
+				if mask&(1<<uint(n-1-i)) != 0 {
+					groupingSet = append(groupingSet, colNameStr)
+				}
+			}
+			groupingSets = append(groupingSets, groupingSet)
+		}
+		return groupingSets, nil
+	case "grouping_sets":
+		for _, selectExpr := range funcExpr.Exprs {
+			aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr)
+			if !ok {
+				err = errorf(ErrSyntax, sqlparser.String(selectExpr), `esql: invalid grouping set %v`, sqlparser.String(selectExpr))
+				return nil, err
+			}
+			var groupingSet []string
+			var exprs []sqlparser.Expr
+			switch expr := aliasedExpr.Expr.(type) {
+			case sqlparser.ValTuple:
+				exprs = expr
+			case *sqlparser.ParenExpr:
+				exprs = []sqlparser.Expr{expr.Expr}
+			default:
+				exprs = []sqlparser.Expr{expr}
+			}
+			for _, expr := range exprs {
+				// () is rewritten to (null) before parsing
+				if _, ok := expr.(*sqlparser.NullVal); ok && len(exprs) == 1 {
+					break
+				}
+				colName, ok := expr.(*sqlparser.ColName)
+				if !ok {
+					err = errorf(ErrSyntax, sqlparser.String(aliasedExpr), `esql: invalid grouping set %v`, sqlparser.String(aliasedExpr))
+					return nil, err
+				}
+				colNameStr, err := e.convertColName(colName)
+				if err != nil {
+					return nil, err
+				}
+				groupingSet = append(groupingSet, colNameStr)
+			}
+			groupingSets = append(groupingSets, groupingSet)
+		}
+		return groupingSets, nil
+	default:
+		err = errorf(ErrUnsupported, sqlparser.String(funcExpr), `esql: GROUP BY %v not supported`, funcName)
+		return nil, err
+	}
+}
+
+func (e *ESql) convertGroupingSetColName(selectExpr sqlparser.SelectExpr) (string, error) {
+	aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr)
+	if !ok {
+		err := errorf(ErrSyntax, sqlparser.String(selectExpr), `esql: invalid grouping column %v`, sqlparser.String(selectExpr))
+		return "", err
+	}
+	colName, ok := aliasedExpr.Expr.(*sqlparser.ColName)
+	if !ok {
+		err := errorf(ErrSyntax, sqlparser.String(selectExpr), `esql: invalid grouping column %v`, sqlparser.String(selectExpr))
+		return "", err
+	}
+	return e.convertColName(colName)
+}
+
+// crossGroupingSets concatenates every grouping set in lhs with every grouping set in rhs,
+// duplicated columns and duplicated grouping sets are removed
+func crossGroupingSets(lhs [][]string, rhs [][]string) (groupingSets [][]string) {
+	groupingSetSet := make(map[string]int)
+	for _, lhsSet := range lhs {
+		for _, rhsSet := range rhs {
+			var groupingSet []string
+			colNameSet := make(map[string]int)
+			for _, colNameStr := range append(append([]string{}, lhsSet...), rhsSet...) {
+				if _, exist := colNameSet[colNameStr]; !exist {
+					colNameSet[colNameStr] = 1
+					groupingSet = append(groupingSet, colNameStr)
+				}
+			}
+			key := strings.Join(groupingSet, ",")
+			if _, exist := groupingSetSet[key]; !exist {
+				groupingSetSet[key] = 1
+				groupingSets = append(groupingSets, groupingSet)
+			}
+		}
+	}
+	return groupingSets
+}
+
+func (e *ESql) convertGroupingSet(groupingSet []string) (dsl string, err error) {
+	var groupByStrSlice []string
+	for _, colNameStr := range groupingSet {
+		field, err := e.aggField("group by", colNameStr)
+		if err != nil {
 			return "", err
 		}
+		groupByStr := fmt.Sprintf(`{"group_%v": {"terms": {"field": "%v", "missing_bucket": true}}}`, colNameStr, field)
+		groupByStrSlice = append(groupByStrSlice, groupByStr)
 	}
-	if len(groupByStrSlice) > 0 {
-		dsl = strings.Join(groupByStrSlice, ",")
-		dsl = fmt.Sprintf(`"composite": {"size": %v, "sources": [%v]}`, e.bucketNumber, dsl)
+	// composite requires at least 1 source, the grand total bucket groups by a constant
+	if len(groupByStrSlice) == 0 {
+		groupByStrSlice = append(groupByStrSlice, `{"_total": {"terms": {"script": {"source": "'total'", "lang": "painless"}}}}`)
 	}
+	dsl = strings.Join(groupByStrSlice, ",")
+	dsl = fmt.Sprintf(`"composite": {"size": %v, "sources": [%v]}`, e.bucketNumber, dsl)
 	return dsl, nil
 }
 
-func (e *ESql) convertFuncExpr(funcExpr sqlparser.FuncExpr) (tag string, body string, err error) {
+// groupingFuncs holds GROUPING(colName) functions in SELECT, in the order they appear
+type groupingFuncs struct {
+	tags     []string
+	colNames map[string]string
+}
+
+func (e *ESql) convertGroupingFuncs(exprs sqlparser.SelectExprs, groupingSets [][]string) (remaining sqlparser.SelectExprs, funcs groupingFuncs, err error) {
+	funcs.colNames = make(map[string]string)
+	for _, selectExpr := range exprs {
+		aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr)
+		if !ok {
+			remaining = append(remaining, selectExpr)
+			continue
+		}
+		funcExpr, ok := aliasedExpr.Expr.(*sqlparser.FuncExpr)
+		if !ok || strings.ToLower(funcExpr.Name.String()) != "grouping" {
+			remaining = append(remaining, selectExpr)
+			continue
+		}
+		if len(groupingSets) == 0 {
+			err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: GROUPING used without GROUP BY`)
+			return nil, funcs, err
+		}
+		if len(funcExpr.Exprs) != 1 {
+			err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: GROUPING requires exactly 1 column`)
+			return nil, funcs, err
+		}
+		colNameStr, err := e.convertGroupingSetColName(funcExpr.Exprs[0])
+		if err != nil {
+			return nil, funcs, err
+		}
+		grouped := false
+		for _, groupingSet := range groupingSets {
+			for _, groupByColNameStr := range groupingSet {
+				if groupByColNameStr == colNameStr {
+					grouped = true
+				}
+			}
+		}
+		if !grouped {
+			err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: GROUPING(%v) on a column not in GROUP BY`, colNameStr)
+			return nil, funcs, err
+		}
+		tag := sqlparser.String(aliasedExpr.As)
+		if tag == "" {
+			tag = "grouping_" + strings.Replace(colNameStr, ".", "_", -1)
+		}
+		if _, exist := funcs.colNames[tag]; !exist {
+			funcs.tags = append(funcs.tags, tag)
+			funcs.colNames[tag] = colNameStr
+		}
+	}
+	return remaining, funcs, nil
+}
+
+// aggregation is the body of an aggregation and the path from its tag to the value it computes, which is
+// below the tag if the aggregation wraps a metric by filter, nested or reverse_nested aggregations
+type aggregation struct {
+	body string
+	path string
+	expr string // the selected function it computes, empty if it is not a selected function
+}
+
+// bucketPath returns the buckets_path of the aggregation named tag
+func (agg aggregation) bucketPath(tag string) string {
+	return tag + agg.path
+}
+
+// selectedAggregation returns the tag of the aggregation that computes funcExpr in SELECT, so that HAVING and
+// ORDER BY reuse it rather than aggregate it again under another tag
+func selectedAggregation(funcExpr *sqlparser.FuncExpr, aggMaps map[string]aggregation) (tag string, ok bool) {
+	exprStr := sqlparser.String(funcExpr)
+	for aggTag, agg := range aggMaps {
+		// the least tag if a function is selected twice, so that the dsl is stable
+		if agg.expr == exprStr && (!ok || aggTag < tag) {
+			tag, ok = aggTag, true
+		}
+	}
+	return tag, ok
+}
+
+// bucketPaths returns the buckets_path of a pipeline aggregation that references every aggregation of aggMaps
+func bucketPaths(aggMaps map[string]aggregation) string {
+	var bucketPathSlice []string
+	for tag, agg := range aggMaps {
+		bucketPathSlice = append(bucketPathSlice, fmt.Sprintf(`"%v": "%v"`, tag, agg.bucketPath(tag)))
+	}
+	return strings.Join(bucketPathSlice, ",")
+}
+
+func (e *ESql) convertFuncExpr(funcExpr sqlparser.FuncExpr) (tag string, agg aggregation, err error) {
 	aggNameStr := strings.ToLower(funcExpr.Name.String())
+	if caseExpr, ok := caseAggregationArgument(funcExpr); ok {
+		tag, agg, err = e.convertCaseAggregation(funcExpr, caseExpr)
+		if err != nil {
+			return "", aggregation{}, err
+		}
+		return tag, agg, nil
+	}
 	switch aggNameStr {
+	case "aggregate_filter":
+		tag, agg, err = e.convertFilteredAggregation(funcExpr)
 	case "count":
-		tag, body, err = e.convertCount(funcExpr)
+		tag, agg.body, err = e.convertCount(funcExpr)
+		if err == nil && tag != "_count" {
+			agg, err = e.nestedFuncBody(funcExpr, agg.body)
+		}
 	case "avg", "sum", "min", "max":
-		tag, body, err = e.convertStandardArithmetic(funcExpr)
+		tag, agg.body, err = e.convertStandardArithmetic(funcExpr)
+		if err == nil {
+			agg, err = e.nestedFuncBody(funcExpr, agg.body)
+		}
 	case "histogram":
-		tag, body, err = e.convertHistogram(funcExpr)
+		tag, agg.body, err = e.convertHistogram(funcExpr)
 	case "date_histogram":
-		tag, body, err = e.convertDateHistogram(funcExpr)
+		tag, agg.body, err = e.convertDateHistogram(funcExpr)
 	case "range":
-		tag, body, err = e.convertRange(funcExpr)
+		tag, agg.body, err = e.convertRange(funcExpr)
 	case "date_range":
-		tag, body, err = e.convertDateRange(funcExpr)
+		tag, agg.body, err = e.convertDateRange(funcExpr)
+	case "ip_range":
+		tag, agg.body, err = e.convertIPRange(funcExpr)
+	case "geo_bounds", "geo_centroid", "geohash_grid":
+		tag, agg.body, err = e.convertGeoAggregation(funcExpr)
 	default:
-		err := fmt.Errorf(`esql: aggregation function %v not supported`, aggNameStr)
-		return "", "", err
+		err := errorf(ErrUnsupported, sqlparser.String(&funcExpr), `esql: aggregation function %v not supported`, aggNameStr)
+		return "", aggregation{}, err
 	}
 	if err != nil {
-		return "", "", err
+		return "", aggregation{}, err
 	}
 	tag = strings.Trim(tag, "'")
-	return tag, body, nil
+	return tag, agg, nil
 }
-
@@ -0,0 +1,420 @@
+package esql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// dateUnit describes how a unit of INTERVAL, DATE_TRUNC and EXTRACT is translated
+type dateUnit struct {
+	dateMath string // unit in es date math, empty if es does not support it
+	chrono   string // painless TemporalUnit for date arithmetics, empty if not supported
+	extract  string // painless method of ZonedDateTime that extracts the unit
+	trunc    string // painless methods of ZonedDateTime that truncate it to the unit, empty if not supported
+}
+
+var dateUnits = map[string]dateUnit{
+	"second":  {"s", "ChronoUnit.SECONDS", "getSecond()", "truncatedTo(ChronoUnit.SECONDS)"},
+	"minute":  {"m", "ChronoUnit.MINUTES", "getMinute()", "truncatedTo(ChronoUnit.MINUTES)"},
+	"hour":    {"h", "ChronoUnit.HOURS", "getHour()", "truncatedTo(ChronoUnit.HOURS)"},
+	"day":     {"d", "ChronoUnit.DAYS", "getDayOfMonth()", "truncatedTo(ChronoUnit.DAYS)"},
+	"week":    {"w", "ChronoUnit.WEEKS", "get(IsoFields.WEEK_OF_WEEK_BASED_YEAR)", "with(ChronoField.DAY_OF_WEEK, 1).truncatedTo(ChronoUnit.DAYS)"},
+	"month":   {"M", "ChronoUnit.MONTHS", "getMonthValue()", "withDayOfMonth(1).truncatedTo(ChronoUnit.DAYS)"},
+	"quarter": {"", "IsoFields.QUARTER_YEARS", "get(IsoFields.QUARTER_OF_YEAR)", "with(IsoFields.DAY_OF_QUARTER, 1).truncatedTo(ChronoUnit.DAYS)"},
+	"year":    {"y", "ChronoUnit.YEARS", "getYear()", "withDayOfYear(1).truncatedTo(ChronoUnit.DAYS)"},
+	// day of week, 0 is sunday
+	"dow": {"", "", "getDayOfWeek().getValue() % 7", ""},
+	// day of week, 1 is monday
+	"isodow": {"", "", "getDayOfWeek().getValue()", ""},
+	"doy":    {"", "", "getDayOfYear()", ""},
+}
+
+// date functions, and their min and max number of arguments
+var dateFuncs = map[string][2]int{
+	"now":               {0, 0},
+	"current_timestamp": {0, 0},
+	"localtime":         {0, 0},
+	"localtimestamp":    {0, 0},
+	"current_date":      {0, 0},
+	"curdate":           {0, 0},
+	"date_trunc":        {2, 2},
+	"date_add":          {2, 2},
+	"date_sub":          {2, 2},
+	"extract":           {2, 2},
+}
+
+// timeNow is the clock NOW() reads when it has to be evaluated in painless
+var timeNow = time.Now
+
+func lookupDateUnit(unit string) (dateUnit, error) {
+	unitStr := strings.ToLower(unit)
+	if u, exist := dateUnits[unitStr]; exist {
+		return u, nil
+	}
+	if u, exist := dateUnits[strings.TrimSuffix(unitStr, "s")]; exist {
+		return u, nil
+	}
+	err := errorf(ErrUnsupported, unit, `esql: date unit %v not supported`, unit)
+	return dateUnit{}, err
+}
+
+// the unit of DATE_TRUNC and EXTRACT is a string literal, e.g. DATE_TRUNC('day', colA)
+func dateUnitArg(funcName string, expr sqlparser.Expr) (dateUnit, error) {
+	val, ok := expr.(*sqlparser.SQLVal)
+	if !ok || val.Type != sqlparser.StrVal {
+		err := errorf(ErrSyntax, sqlparser.String(expr), `esql: the unit of %v should be a string literal`, funcName)
+		return dateUnit{}, err
+	}
+	return lookupDateUnit(string(val.Val))
+}
+
+func isDateFunc(funcExpr *sqlparser.FuncExpr) bool {
+	_, exist := dateFuncs[strings.ToLower(funcExpr.Name.String())]
+	return exist
+}
+
+// isDateExpr reports whether expr evaluates to a date, dates are epoch millis in painless
+func isDateExpr(expr sqlparser.Expr) bool {
+	switch expr := expr.(type) {
+	case *sqlparser.ParenExpr:
+		return isDateExpr(expr.Expr)
+	case *sqlparser.FuncExpr:
+		return isDateFunc(expr) && strings.ToLower(expr.Name.String()) != "extract"
+	case *sqlparser.BinaryExpr:
+		if expr.Operator != sqlparser.PlusStr && expr.Operator != sqlparser.MinusStr {
+			return false
+		}
+		_, lhsInterval := expr.Left.(*sqlparser.IntervalExpr)
+		_, rhsInterval := expr.Right.(*sqlparser.IntervalExpr)
+		return lhsInterval || rhsInterval
+	}
+	return false
+}
+
+func dateFuncArgs(funcExpr *sqlparser.FuncExpr) (funcName string, args []sqlparser.Expr, err error) {
+	funcName = strings.ToLower(funcExpr.Name.String())
+	args, err = funcExprArgs(funcExpr)
+	if err != nil {
+		return "", nil, err
+	}
+	argNum := dateFuncs[funcName]
+	if len(args) < argNum[0] || len(args) > argNum[1] {
+		err = errorf(ErrSyntax, sqlparser.String(funcExpr), "esql: wrong number of arguments for function %v", funcName)
+		return "", nil, err
+	}
+	return funcName, args, nil
+}
+
+// convertDateMath translates expr to es date math, e.g. NOW() - INTERVAL 7 DAY -> now-7d,
+// ok is false if expr can not be expressed in date math
+func (e *ESql) convertDateMath(expr sqlparser.Expr) (dateMath string, ok bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.ParenExpr:
+		return e.convertDateMath(expr.Expr)
+	case *sqlparser.FuncExpr:
+		if !isDateFunc(expr) {
+			return "", false
+		}
+		funcName, args, err := dateFuncArgs(expr)
+		if err != nil {
+			return "", false
+		}
+		switch funcName {
+		case "now", "current_timestamp", "localtime", "localtimestamp":
+			return "now", true
+		case "current_date", "curdate":
+			return "now/d", true
+		case "date_trunc":
+			unit, err := dateUnitArg(funcName, args[0])
+			if err != nil || unit.dateMath == "" {
+				return "", false
+			}
+			if dateMath, ok = e.convertDateMath(args[1]); ok {
+				return dateMath + "/" + unit.dateMath, true
+			}
+		case "date_add", "date_sub":
+			sign := "+"
+			if funcName == "date_sub" {
+				sign = "-"
+			}
+			return e.convertDateMathArith(args[0], args[1], sign)
+		}
+	case *sqlparser.BinaryExpr:
+		switch expr.Operator {
+		case sqlparser.PlusStr:
+			if _, ok := expr.Left.(*sqlparser.IntervalExpr); ok {
+				return e.convertDateMathArith(expr.Right, expr.Left, "+")
+			}
+			return e.convertDateMathArith(expr.Left, expr.Right, "+")
+		case sqlparser.MinusStr:
+			return e.convertDateMathArith(expr.Left, expr.Right, "-")
+		}
+	}
+	return "", false
+}
+
+// date +/- INTERVAL n unit -> date+nunit, n should be an integer literal
+func (e *ESql) convertDateMathArith(dateExpr sqlparser.Expr, intervalExpr sqlparser.Expr, sign string) (dateMath string, ok bool) {
+	interval, ok := intervalExpr.(*sqlparser.IntervalExpr)
+	if !ok {
+		return "", false
+	}
+	unit, err := lookupDateUnit(interval.Unit)
+	if err != nil || unit.dateMath == "" {
+		return "", false
+	}
+	n := interval.Expr
+	if unaryExpr, ok := n.(*sqlparser.UnaryExpr); ok && unaryExpr.Operator == sqlparser.UMinusStr {
+		n = unaryExpr.Expr
+		sign = map[string]string{"+": "-", "-": "+"}[sign]
+	}
+	val, ok := n.(*sqlparser.SQLVal)
+	if !ok || val.Type != sqlparser.IntVal {
+		return "", false
+	}
+	dateMath, ok = e.convertDateMath(dateExpr)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf(`%v%v%v%v`, dateMath, sign, string(val.Val), unit.dateMath), true
+}
+
+// colName compares to date math, e.g. colA > NOW() - INTERVAL 7 DAY -> {"range": {"colA": {"gt": "now-7d"}}}
+// note that es rounds date math by the operator, e.g. lte now/d includes the whole day
+func (e *ESql) convertDateMathComparison(lhs *sqlparser.ColName, op string, dateMath string) (dsl string, err error) {
+	lhsStr, err := e.convertColName(lhs)
+	if err != nil {
+		return "", err
+	}
+	// time zone affects the rounding of date math
+	params := e.dateRangeParams("")
+	switch op {
+	case "=":
+		dsl = fmt.Sprintf(`{"range": {"%v": {"gte": "%v", "lte": "%v"%v}}}`, lhsStr, dateMath, dateMath, params)
+	case "<":
+		dsl = fmt.Sprintf(`{"range": {"%v": {"lt": "%v"%v}}}`, lhsStr, dateMath, params)
+	case "<=":
+		dsl = fmt.Sprintf(`{"range": {"%v": {"lte": "%v"%v}}}`, lhsStr, dateMath, params)
+	case ">":
+		dsl = fmt.Sprintf(`{"range": {"%v": {"gt": "%v"%v}}}`, lhsStr, dateMath, params)
+	case ">=":
+		dsl = fmt.Sprintf(`{"range": {"%v": {"gte": "%v"%v}}}`, lhsStr, dateMath, params)
+	case "<>", "!=":
+		dsl = e.mustNot(lhsStr, fmt.Sprintf(`{"range": {"%v": {"gte": "%v", "lte": "%v"%v}}}`, lhsStr, dateMath, dateMath, params))
+	default:
+		err = errorf(ErrUnsupported, op, `esql: %s operator not supported for date comparison`, op)
+		return "", err
+	}
+	return dsl, nil
+}
+
+// convertToDateScript returns the painless script of expr in epoch millis
+func (e *ESql) convertToDateScript(expr sqlparser.Expr, aggMaps map[string]aggregation) (script string, err error) {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		colNameStr, err := e.convertColName(expr)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(`doc['%v'].value.getMillis()`, colNameStr), nil
+	case *sqlparser.SQLVal:
+		if expr.Type != sqlparser.StrVal {
+			break
+		}
+		// painless can not compare a string to millis, so a date literal is parsed here
+		millis, ok := e.dateLiteralMillis(string(expr.Val))
+		if !ok {
+			err = errorf(ErrSyntax, sqlparser.String(expr), `esql: %v is not a date literal`, sqlparser.String(expr))
+			return "", err
+		}
+		if e.docScript != nil {
+			return e.docScript.param(literal{literalInt, strconv.FormatInt(millis, 10)}), nil
+		}
+		return fmt.Sprintf(`%dL`, millis), nil
+	case *sqlparser.ParenExpr:
+		return e.convertToDateScript(expr.Expr, aggMaps)
+	case *sqlparser.BinaryExpr:
+		if !isDateExpr(expr) {
+			break
+		}
+		if lhs, ok := expr.Left.(*sqlparser.IntervalExpr); ok {
+			if expr.Operator != sqlparser.PlusStr {
+				err = errorf(ErrSyntax, sqlparser.String(expr), `esql: INTERVAL can not be subtracted by a date`)
+				return "", err
+			}
+			return e.convertDateArithToScript(expr.Right, lhs, "plus", aggMaps)
+		}
+		method := "plus"
+		if expr.Operator == sqlparser.MinusStr {
+			method = "minus"
+		}
+		return e.convertDateArithToScript(expr.Left, expr.Right.(*sqlparser.IntervalExpr), method, aggMaps)
+	}
+	return e.convertToScript(expr, aggMaps)
+}
+
+func (e *ESql) convertDateFuncToScript(funcExpr *sqlparser.FuncExpr, aggMaps map[string]aggregation) (script string, err error) {
+	funcName, args, err := dateFuncArgs(funcExpr)
+	if err != nil {
+		return "", err
+	}
+	switch funcName {
+	case "now", "current_timestamp", "localtime", "localtimestamp":
+		script = e.nowScript()
+	case "current_date", "curdate":
+		script = fmt.Sprintf(`%v.truncatedTo(ChronoUnit.DAYS).toInstant().toEpochMilli()`, e.zonedDateTimeScript(e.nowScript()))
+	case "date_trunc", "extract":
+		unit, err := dateUnitArg(funcName, args[0])
+		if err != nil {
+			return "", err
+		}
+		date, err := e.convertToDateScript(args[1], aggMaps)
+		if err != nil {
+			return "", err
+		}
+		if funcName == "extract" {
+			script = fmt.Sprintf(`%v.%v`, e.zonedDateTimeScript(date), unit.extract)
+			break
+		}
+		if unit.trunc == "" {
+			err = errorf(ErrUnsupported, sqlparser.String(args[0]), `esql: DATE_TRUNC to %v not supported`, strings.Trim(sqlparser.String(args[0]), `'`))
+			return "", err
+		}
+		script = fmt.Sprintf(`%v.%v.toInstant().toEpochMilli()`, e.zonedDateTimeScript(date), unit.trunc)
+	case "date_add", "date_sub":
+		interval, ok := args[1].(*sqlparser.IntervalExpr)
+		if !ok {
+			err = errorf(ErrSyntax, sqlparser.String(args[1]), `esql: the second argument of %v should be an INTERVAL`, funcName)
+			return "", err
+		}
+		method := "plus"
+		if funcName == "date_sub" {
+			method = "minus"
+		}
+		script, err = e.convertDateArithToScript(args[0], interval, method, aggMaps)
+	}
+	if err != nil {
+		return "", err
+	}
+	return script, nil
+}
+
+// nowScript returns the current time in millis. in a script on doc values it is a param, otherwise es
+// compiles the script again for every query
+func (e *ESql) nowScript() string {
+	millis := timeNow().UnixNano() / int64(time.Millisecond)
+	if e.docScript != nil {
+		return e.docScript.param(literal{literalInt, strconv.FormatInt(millis, 10)})
+	}
+	return fmt.Sprintf(`%dL`, millis)
+}
+
+// date +/- INTERVAL n unit in painless, calendar units like month are supported as well
+func (e *ESql) convertDateArithToScript(dateExpr sqlparser.Expr, interval *sqlparser.IntervalExpr, method string, aggMaps map[string]aggregation) (script string, err error) {
+	unit, err := lookupDateUnit(interval.Unit)
+	if err != nil {
+		return "", err
+	}
+	if unit.chrono == "" {
+		err = errorf(ErrUnsupported, sqlparser.String(interval), `esql: INTERVAL of %v not supported`, interval.Unit)
+		return "", err
+	}
+	date, err := e.convertToDateScript(dateExpr, aggMaps)
+	if err != nil {
+		return "", err
+	}
+	n, err := e.convertToScript(interval.Expr, aggMaps)
+	if err != nil {
+		return "", err
+	}
+	script = fmt.Sprintf(`%v.%v((long) (%v), %v).toInstant().toEpochMilli()`, e.zonedDateTimeScript(date), method, n, unit.chrono)
+	return script, nil
+}
+
+func (e *ESql) zonedDateTimeScript(millis string) string {
+	zone := "ZoneOffset.UTC"
+	if e.timeZone != "" {
+		zone = fmt.Sprintf(`ZoneId.of('%v')`, e.timeZone)
+	}
+	return fmt.Sprintf(`ZonedDateTime.ofInstant(Instant.ofEpochMilli(%v), %v)`, millis, zone)
+}
+
+// date literals in range queries, and the es format to parse them
+var dateLiteralFormats = []struct {
+	layout string
+	format string
+}{
+	{"2006-01-02", "yyyy-MM-dd"},
+	{"2006-01-02 15:04:05", "yyyy-MM-dd HH:mm:ss"},
+	{"2006-01-02 15:04:05.000", "yyyy-MM-dd HH:mm:ss.SSS"},
+	{"2006-01-02T15:04:05", "strict_date_optional_time"},
+	{"2006-01-02T15:04:05.000", "strict_date_optional_time"},
+	{"2006-01-02T15:04:05Z07:00", "strict_date_optional_time"},
+	{"2006-01-02T15:04:05.000Z07:00", "strict_date_optional_time"},
+}
+
+// dateLiteralFormat returns the es format of val if val is a date literal
+func dateLiteralFormat(val string) (format string, ok bool) {
+	for _, f := range dateLiteralFormats {
+		if _, err := time.Parse(f.layout, val); err == nil {
+			return f.format, true
+		}
+	}
+	return "", false
+}
+
+// isDateLiteral reports whether expr is a string literal in a known date format
+func isDateLiteral(expr sqlparser.Expr) bool {
+	val, ok := expr.(*sqlparser.SQLVal)
+	if !ok || val.Type != sqlparser.StrVal {
+		return false
+	}
+	_, ok = dateLiteralFormat(string(val.Val))
+	return ok
+}
+
+// dateLiteralMillis returns the epoch millis of a date literal, which is in the time zone of e unless
+// it has its own
+func (e *ESql) dateLiteralMillis(val string) (millis int64, ok bool) {
+	loc, ok := timeLocation(e.timeZone)
+	if !ok {
+		return 0, false
+	}
+	for _, f := range dateLiteralFormats {
+		if t, err := time.ParseInLocation(f.layout, val, loc); err == nil {
+			return t.UnixNano() / int64(time.Millisecond), true
+		}
+	}
+	return 0, false
+}
+
+// dateFieldFormat returns the es format of lit if colName is a date field, ok is false for other fields. the
+// mapping tells whether colName is a date field, w/o mapping a field compared to a date literal is a date field.
+// format is empty if lit is not in a known date format, es parses it by the format of the field then
+func (e *ESql) dateFieldFormat(colName string, lit literal) (format string, ok bool) {
+	if lit.typ != literalString {
+		return "", false
+	}
+	format, isDateLiteral := dateLiteralFormat(lit.val)
+	if mapping, exist := e.fieldMapping(colName); exist {
+		return format, mapping.Type == "date" || mapping.Type == "date_nanos"
+	}
+	return format, isDateLiteral
+}
+
+// dateRangeParams returns the date parameters of a range query, e.g. , "format": "yyyy-MM-dd", "time_zone": "+08:00"
+// format is omitted if empty, time_zone is omitted if not set. a time zone in a date literal takes precedence
+func (e *ESql) dateRangeParams(format string) string {
+	var params string
+	if format != "" {
+		params += fmt.Sprintf(`, "format": "%v"`, format)
+	}
+	if e.timeZone != "" {
+		params += fmt.Sprintf(`, "time_zone": "%v"`, e.timeZone)
+	}
+	return params
+}
@@ -0,0 +1,174 @@
+package esql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DecodeGroupBy ...
+// Flatten the buckets of a GROUP BY query response into rows
+//
+// usage:
+//  - rows, err := DecodeGroupBy(response)
+//
+// arguments:
+//  - response: the raw json response elasticsearch returns for the dsl
+//
+// return values:
+//  - rows: one map per bucket, keyed by column name and aggregation tag. document count is keyed by "_count".
+//    buckets of all grouping sets are merged, GROUP BY columns rolled up in a bucket are nil
+//  - err: contains err information
+func DecodeGroupBy(response []byte) (rows []map[string]interface{}, err error) {
+	var resp struct {
+		Aggregations map[string]json.RawMessage `json:"aggregations"`
+	}
+	if err = json.Unmarshal(response, &resp); err != nil {
+		return nil, err
+	}
+	// GROUP BY nested columns is wrapped in a nested aggregation
+	if raw, exist := resp.Aggregations["nested"]; exist {
+		if err = json.Unmarshal(raw, &resp.Aggregations); err != nil {
+			return nil, err
+		}
+	}
+
+	// grouping sets are tagged groupby, groupby_1, groupby_2, ...
+	type compositeAgg struct {
+		Buckets []map[string]interface{} `json:"buckets"`
+		// GROUP BY GEOHASH_GRID names the key of its buckets
+		Meta struct {
+			Key string `json:"key"`
+		} `json:"meta"`
+	}
+	var groupBys []compositeAgg
+	for i := 0; ; i++ {
+		tag := "groupby"
+		if i > 0 {
+			tag = fmt.Sprintf(`groupby_%v`, i)
+		}
+		raw, exist := resp.Aggregations[tag]
+		if !exist {
+			break
+		}
+		var groupBy compositeAgg
+		if err = json.Unmarshal(raw, &groupBy); err != nil {
+			return nil, err
+		}
+		groupBys = append(groupBys, groupBy)
+	}
+	if len(groupBys) == 0 {
+		err = errorf(ErrResponse, "", `esql: no GROUP BY aggregation in response`)
+		return nil, err
+	}
+
+	// collect GROUP BY columns of all grouping sets so that each row has the same columns
+	var colNameSlice []string
+	colNameSet := make(map[string]int)
+	for _, groupBy := range groupBys {
+		for _, bucket := range groupBy.Buckets {
+			key, _ := bucket["key"].(map[string]interface{})
+			for k := range key {
+				if !strings.HasPrefix(k, "group_") {
+					continue
+				}
+				if _, exist := colNameSet[k]; !exist {
+					colNameSet[k] = 1
+					colNameSlice = append(colNameSlice, k)
+				}
+			}
+		}
+	}
+
+	for _, groupBy := range groupBys {
+		for _, bucket := range groupBy.Buckets {
+			row := make(map[string]interface{})
+			key, _ := bucket["key"].(map[string]interface{})
+			for _, k := range colNameSlice {
+				row[strings.TrimPrefix(k, "group_")] = key[k]
+			}
+			if groupBy.Meta.Key != "" {
+				row[groupBy.Meta.Key] = bucket["key"]
+			}
+			for tag, v := range bucket {
+				switch tag {
+				case "key":
+				case "doc_count":
+					row["_count"] = v
+				default:
+					row[tag] = decodeAggValue(v)
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// decodeAggValue extracts the value of a metric aggregation, e.g. {"value": 1} -> 1,
+// a filtered aggregation gives its metric, or its doc count if there is no metric
+func decodeAggValue(v interface{}) interface{} {
+	metric, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	if value, exist := metric["value"]; exist {
+		return decodeAggValue(value)
+	}
+	if count, exist := metric["doc_count"]; exist {
+		return count
+	}
+	return metric
+}
+
+// DecodeHits ...
+// Flatten the hits of a query response into rows
+//
+// usage:
+//  - rows, err := DecodeHits(response)
+//
+// arguments:
+//  - response: the raw json response elasticsearch returns for the dsl
+//
+// return values:
+//  - rows: one map per hit, the fields of its _source plus its "_id". if HAS_CHILD or HAS_PARENT returns
+//    inner_hits, the matching children or parent are keyed by type under "_inner_hits", decoded as rows as well.
+//    the inner_hits of a later predicate on the same type are keyed by the type and its index, e.g. "answer_1"
+//  - err: contains err information
+func DecodeHits(response []byte) (rows []map[string]interface{}, err error) {
+	var resp searchHits
+	if err = json.Unmarshal(response, &resp); err != nil {
+		return nil, err
+	}
+	return resp.rows(), nil
+}
+
+type searchHits struct {
+	Hits struct {
+		Hits []struct {
+			ID        string                 `json:"_id"`
+			Source    map[string]interface{} `json:"_source"`
+			InnerHits map[string]searchHits  `json:"inner_hits"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func (h searchHits) rows() []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(h.Hits.Hits))
+	for _, hit := range h.Hits.Hits {
+		row := make(map[string]interface{}, len(hit.Source)+2)
+		for k, v := range hit.Source {
+			row[k] = v
+		}
+		row["_id"] = hit.ID
+		if len(hit.InnerHits) > 0 {
+			innerHits := make(map[string][]map[string]interface{}, len(hit.InnerHits))
+			for name, inner := range hit.InnerHits {
+				innerHits[name] = inner.rows()
+			}
+			row["_inner_hits"] = innerHits
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
@@ -0,0 +1,177 @@
+package esql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrorCode ...
+// ErrorCode classifies the errors esql returns
+type ErrorCode int
+
+const (
+	// ErrUnsupported means the query uses a sql feature esql cannot translate to dsl
+	ErrUnsupported ErrorCode = iota + 1
+	// ErrSyntax means the query is not valid sql, or a function or expression is used in a wrong way
+	ErrSyntax
+	// ErrPolicy means the query is valid but violates a setting or the schema, e.g. an unknown column
+	ErrPolicy
+	// ErrMacro means a user specified ProcessFunc failed
+	ErrMacro
+	// ErrResponse means a response of es is not what esql expects, or reports a failure
+	ErrResponse
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrUnsupported:
+		return "unsupported"
+	case ErrSyntax:
+		return "syntax"
+	case ErrPolicy:
+		return "policy"
+	case ErrMacro:
+		return "macro"
+	case ErrResponse:
+		return "response"
+	default:
+		return "unknown"
+	}
+}
+
+// Error ...
+// Error is the error type returned by Convert, ConvertPretty and Validate, and by decoding responses of es
+//
+// fields:
+//  - Code: what kind of error it is
+//  - Message: the error message
+//  - Fragment: the sql fragment that causes the error, empty if the error is not caused by a specific fragment
+//  - Position: 0 based byte offset of the fragment in the sql, -1 if unknown
+//  - Err: the underlying error, e.g. the error returned by a ProcessFunc
+type Error struct {
+	Code     ErrorCode
+	Message  string
+	Fragment string
+	Position int
+	Err      error
+}
+
+func (e *Error) Error() string {
+	if e.Position < 0 {
+		return e.Message
+	}
+	return fmt.Sprintf(`%v at position %v`, e.Message, e.Position)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// errorf returns an *Error of code, fragment is the sql the error is about, its position is
+// filled when the whole sql is known
+func errorf(code ErrorCode, fragment string, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Fragment: fragment,
+		Position: -1,
+	}
+}
+
+// wrapError turns err into an *Error of code, an *Error is returned unchanged
+func wrapError(code ErrorCode, fragment string, err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{
+		Code:     code,
+		Message:  err.Error(),
+		Fragment: fragment,
+		Position: -1,
+		Err:      err,
+	}
+}
+
+var parseErrorRegexp = regexp.MustCompile(`at position (\d+) near '(.*)'$`)
+
+// wrapParseError turns a sqlparser error into an *Error, sqlparser reports the position right
+// after the token it stops at
+func wrapParseError(err error) *Error {
+	esqlErr := &Error{
+		Code:     ErrSyntax,
+		Message:  "esql: " + err.Error(),
+		Position: -1,
+		Err:      err,
+	}
+	if match := parseErrorRegexp.FindStringSubmatch(err.Error()); match != nil {
+		esqlErr.Message = "esql: " + strings.TrimSpace(strings.TrimSuffix(err.Error(), match[0]))
+		esqlErr.Fragment = match[2]
+		if pos, convErr := strconv.Atoi(match[1]); convErr == nil && pos >= len(match[2]) {
+			esqlErr.Position = pos - len(match[2])
+		}
+	}
+	return esqlErr
+}
+
+// locateError fills the position of err in sql, sql is the query before rewriting so the
+// position sqlparser reports is not used, the fragment is searched instead
+func locateError(sql string, err error) error {
+	if esqlErr, ok := err.(*Error); ok {
+		esqlErr.Position = locatePosition(sql, esqlErr.Fragment, esqlErr.Position)
+	}
+	return err
+}
+
+// locatePosition returns the position of fragment in sql, position is kept if fragment is not
+// found and it is inside sql
+func locatePosition(sql string, fragment string, position int) int {
+	if fragment == "" {
+		return position
+	}
+	if pos := locateFragment(sql, fragment); pos >= 0 {
+		return pos
+	}
+	if position >= len(sql) {
+		return -1
+	}
+	return position
+}
+
+var fragmentTokenRegexp = regexp.MustCompile("'(?:[^'\\\\]|\\\\.)*'|\"(?:[^\"\\\\]|\\\\.)*\"|`[^`]*`|[A-Za-z0-9_.]+|\\S")
+
+// locateFragment returns the position of fragment in sql, or -1. fragments are printed from
+// the parsed query, so keywords may differ in case, identifiers in backticks, strings in quotes
+// and whitespace in length from the original sql
+func locateFragment(sql string, fragment string) int {
+	tokens := fragmentTokenRegexp.FindAllString(fragment, -1)
+	if len(tokens) == 0 {
+		return -1
+	}
+	patterns := make([]string, len(tokens))
+	for i, token := range tokens {
+		switch token[0] {
+		case '\'', '"':
+			patterns[i] = `['"]` + regexp.QuoteMeta(token[1:len(token)-1]) + `['"]`
+		case '`':
+			patterns[i] = "`?" + regexp.QuoteMeta(token[1:len(token)-1]) + "`?"
+		default:
+			if isIdentChar(token[0]) {
+				patterns[i] = "`?" + regexp.QuoteMeta(token) + "`?"
+			} else {
+				patterns[i] = regexp.QuoteMeta(token)
+			}
+		}
+	}
+	fragmentRegexp, err := regexp.Compile(`(?i)` + strings.Join(patterns, `\s*`))
+	if err != nil {
+		return -1
+	}
+	loc := fragmentRegexp.FindStringIndex(sql)
+	if loc == nil {
+		return -1
+	}
+	return loc[0]
+}
@@ -3,7 +3,6 @@ package esql
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 
 	"github.com/xwb1989/sqlparser"
 )
@@ -17,15 +16,38 @@ type ProcessFunc func(string) (string, error)
 // only those FilterFunc(colName) == true will be processed
 type FilterFunc func(string) bool
 
+// IndexFunc ...
+// esql use IndexFunc to map a table name in FROM to the indices, aliases or patterns it stands for,
+// the table name is queried as is if IndexFunc returns no index
+type IndexFunc func(table string) ([]string, error)
+
 // ESql ...
 // ESql is used to hold necessary information that required in parsing
 type ESql struct {
-	filterKey    FilterFunc  // select the column we want to process key macro
-	filterValue  FilterFunc  // select the column we want to process value macro
-	processKey   ProcessFunc // if selected by filterKey, change the query name
-	processValue ProcessFunc // if selected by filterValue, change the query value
+	filterKey    FilterFunc        // select the column we want to process key macro
+	filterValue  FilterFunc        // select the column we want to process value macro
+	processKey   ProcessFunc       // if selected by filterKey, change the query name
+	processValue ProcessFunc       // if selected by filterValue, change the query value
+	indexMapping IndexFunc         // map a table name to the indices it queries
+	views        map[string]string // the select each view in FROM stands for
+	timeIndices  []TimeIndex       // indices of a period each, pruned by the time range of WHERE
 	pageSize     int
 	bucketNumber int
+	timeZone     string         // time zone of dates in range queries, date aggregations and painless, es uses UTC if empty
+	schema       Schema         // mappings of the indices, optional
+	index        string         // the indices being queried joined by comma, set per query
+	indices      []string       // the indices, aliases and patterns FROM resolves to, set per query
+	tableAliases map[string]int // aliases of the tables in FROM, which qualify column names, set per query
+	explanation  *Explanation   // collects the translation plan if the query is being explained, set per query
+	aggPath      string         // the nested object the buckets of GROUP BY are in, set per query
+	legacyScript bool           // scripts on doc values throw on missing fields and inline literals
+	strictNull   bool           // negations do not match documents missing the field, as in ANSI SQL
+	countNulls   bool           // COUNT(colName) counts documents w/ null values in colName, as in ES SQL
+	innerHits    bool           // HAS_CHILD and HAS_PARENT return the matching children or parent
+	innerHitsOf  map[string]int // the number of inner_hits of each type, which names the next one, set per query
+	negated      bool           // the predicate being converted is under NOT, so it returns no inner_hits
+	docScript    *docScript     // collects the params of the script on doc values being built
+	request      *SearchRequest // collects request level parameters if the query is converted to a request, set per query
 }
 
 // SetDefault ...
@@ -38,6 +60,15 @@ func (e *ESql) SetDefault() {
 	e.filterValue = nil
 	e.processKey = nil
 	e.processValue = nil
+	e.indexMapping = nil
+	e.views = nil
+	e.timeIndices = nil
+	e.timeZone = ""
+	e.schema = nil
+	e.legacyScript = false
+	e.strictNull = false
+	e.countNulls = false
+	e.innerHits = false
 }
 
 // NewESql ... return a new default ESql
@@ -66,12 +97,94 @@ func (e *ESql) ProcessQueryValue(filterArg FilterFunc, processArg ProcessFunc) {
 	e.processValue = processArg
 }
 
+// SetIndexMapping ... set up the indices each table name in FROM queries, e.g. "workflows" to
+// "workflows-v1,workflows-v2"
+// should not be called if there is potential race condition
+func (e *ESql) SetIndexMapping(mapping IndexFunc) {
+	e.indexMapping = mapping
+}
+
+// SetView ... register a view, a name in FROM that stands for sql, a select filtering and projecting
+// a single index. sql is checked when the view is registered
+// should not be called if there is potential race condition
+func (e *ESql) SetView(name string, sql string) error {
+	sql, err := preprocess(sql)
+	if err != nil {
+		return err
+	}
+	if _, err = parseView(name, sql); err != nil {
+		return err
+	}
+	if e.views == nil {
+		e.views = make(map[string]string)
+	}
+	e.views[name] = sql
+	return nil
+}
+
+// SetTimeIndices ... set up indices that hold the documents of a period each. when a query is converted
+// to a request, their patterns in FROM are replaced by the indices the time range of WHERE can be in
+// should not be called if there is potential race condition
+func (e *ESql) SetTimeIndices(timeIndices ...TimeIndex) {
+	e.timeIndices = timeIndices
+}
+
 // SetPageSize ... set the number of documents returned in a non-aggregation query
 // should not be called if there is potential race condition
 func (e *ESql) SetPageSize(pageSizeArg int) {
 	e.pageSize = pageSizeArg
 }
 
+// SetTimeZone ... set the default time zone of dates, e.g. "America/Los_Angeles" or "+08:00"
+// a query can override it by a "SET TIME ZONE '...';" prefix or a /*+ TIME_ZONE('...') */ hint
+// should not be called if there is potential race condition
+func (e *ESql) SetTimeZone(timeZoneArg string) error {
+	if timeZoneArg != "" && !timeZoneRegexp.MatchString(timeZoneArg) {
+		return errorf(ErrPolicy, timeZoneArg, `esql: invalid time zone %v`, timeZoneArg)
+	}
+	e.timeZone = timeZoneArg
+	return nil
+}
+
+// SetSchema ... set up the mappings of the indices. with the mappings, esql queries text fields by match,
+// uses keyword sub fields of text fields for exact match, sorting and aggregations, coerces literals
+// to field types, and rejects aggregations on text fields
+// should not be called if there is potential race condition
+func (e *ESql) SetSchema(schemaArg Schema) {
+	e.schema = schemaArg
+}
+
+// SetLegacyScript ... if legacy is true, painless scripts on doc values read doc['col'].value without checking
+// that the field exists, which fails the shard on documents missing it, and inline literals into the source
+// instead of params. by default a missing field is null and a comparison with null is false
+// should not be called if there is potential race condition
+func (e *ESql) SetLegacyScript(legacy bool) {
+	e.legacyScript = legacy
+}
+
+// SetStrictNull ... if strict is true, NULL follows ANSI SQL in negations: colA != 10, NOT IN, NOT LIKE,
+// NOT REGEXP and NOT BETWEEN do not match documents missing colA, and NOT IN with NULL in the list matches
+// nothing. by default negations are must_not queries, which match documents missing the field
+// should not be called if there is potential race condition
+func (e *ESql) SetStrictNull(strict bool) {
+	e.strictNull = strict
+}
+
+// SetCountNulls ... if countNulls is true, COUNT(colName) counts documents w/ null values in colName as ES SQL
+// does, i.e. it is COUNT(*). by default documents w/ null values are excluded. COUNT(DISTINCT colName) is
+// not affected
+// should not be called if there is potential race condition
+func (e *ESql) SetCountNulls(countNulls bool) {
+	e.countNulls = countNulls
+}
+
+// SetInnerHits ... if innerHits is true, HAS_CHILD and HAS_PARENT return the children or the parent that
+// match as inner_hits, which DecodeHits puts into each row
+// should not be called if there is potential race condition
+func (e *ESql) SetInnerHits(innerHits bool) {
+	e.innerHits = innerHits
+}
+
 // SetBucketNum ... set the number of bucket returned in an aggregation query
 // should not be called if there is potential race condition
 func (e *ESql) SetBucketNum(bucketNumArg int) {
@@ -121,21 +234,59 @@ func (e *ESql) ConvertPretty(sql string, pagination ...interface{}) (dsl string,
 //	- sortField: string array that contains all column names used for sorting. useful for pagination.
 //  - err: contains err information
 func (e *ESql) Convert(sql string, pagination ...interface{}) (dsl string, sortField []string, err error) {
-	stmt, err := sqlparser.Parse(sql)
+	if explainRegexp.MatchString(sql) {
+		err = errorf(ErrUnsupported, "EXPLAIN", `esql: use Explain for EXPLAIN queries`)
+		return "", nil, locateError(sql, err)
+	}
+	conv, stmt, err := e.prepare(sql)
 	if err != nil {
-		return "", nil, err
+		return "", nil, locateError(sql, err)
 	}
 
 	//sql valid, start to handle
 	switch stmt.(type) {
 	case *sqlparser.Select:
-		dsl, sortField, err = e.convertSelect(*(stmt.(*sqlparser.Select)), "", pagination...)
+		dsl, sortField, err = conv.convertSelect(*(stmt.(*sqlparser.Select)), "", pagination...)
+	case *sqlparser.Union:
+		err = errorf(ErrUnsupported, "UNION", `esql: use ConvertUnion for UNION ALL queries`)
 	default:
-		err = fmt.Errorf(`esql: Queries other than select not supported`)
+		err = errorf(ErrUnsupported, "", `esql: Queries other than select not supported`)
 	}
 
 	if err != nil {
-		return "", nil, err
+		return "", nil, locateError(sql, err)
 	}
 	return dsl, sortField, nil
 }
+
+// prepare parses sql, and returns a copy of e with the settings of this query. settings of
+// a query go to the copy so that queries can be converted concurrently
+func (e *ESql) prepare(sql string) (conv *ESql, stmt sqlparser.Statement, err error) {
+	sql, timeZone, err := extractTimeZone(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	sql, err = preprocess(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctes, sql, err := extractCTEs(sql)
+	if err != nil {
+		return nil, nil, err
+	}
+	stmt, err = sqlparser.Parse(sql)
+	if err != nil {
+		return nil, nil, wrapParseError(err)
+	}
+
+	copied := *e
+	conv = &copied
+	if timeZone != "" {
+		conv.timeZone = timeZone
+	}
+	stmt, err = conv.expandViews(stmt, ctes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conv, stmt, nil
+}
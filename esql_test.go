@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/olivere/elastic"
+	"github.com/xwb1989/sqlparser"
 )
 
 var tableName = `test`
@@ -403,6 +404,74 @@ func readQueries(fileName string) ([]string, error) {
 	return sqls, nil
 }
 
+// testFeatureCases converts the sqls of testcases/sqls<feature>.txt by convert, and compares each output to
+// the line of the same number in testcases/dslRef<feature>.txt
+func testFeatureCases(t *testing.T, feature string, convert func(sql string) (string, error)) {
+	sqls, err := readQueries(fmt.Sprintf(`testcases/sqls%v.txt`, feature))
+	if err != nil {
+		t.Fatalf("Fail to load testcases of %v", feature)
+	}
+	dsls, err := readQueries(fmt.Sprintf(`testcases/dslRef%v.txt`, feature))
+	if err != nil || len(dsls) != len(sqls) {
+		t.Fatalf("Fail to load testcases ref of %v", feature)
+	}
+	for i, sql := range sqls {
+		output, err := convert(sql)
+		if err != nil {
+			t.Errorf("%vth %v query fails: %v", i+1, feature, err)
+			continue
+		}
+		if err = compareOutput(output, dsls[i]); err != nil {
+			t.Errorf("%vth %v query does not match: %v\n\t%v", i+1, feature, err, output)
+		}
+	}
+}
+
+// compareOutput compares output, a dsl or the NDJSON of an _msearch, to ref as json. the documents of
+// NDJSON are compared to a json array of them
+func compareOutput(output string, ref string) error {
+	var docs []interface{}
+	decoder := json.NewDecoder(strings.NewReader(output))
+	for decoder.More() {
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+	}
+	var actual, expected interface{} = docs, nil
+	if len(docs) == 1 {
+		actual = docs[0]
+	}
+	if err := json.Unmarshal([]byte(ref), &expected); err != nil {
+		return fmt.Errorf("invalid ref: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		return fmt.Errorf("expects %v", ref)
+	}
+	return nil
+}
+
+// convertDsl converts a sql to dsl by e, for testFeatureCases
+func convertDsl(e *ESql) func(sql string) (string, error) {
+	return func(sql string) (string, error) {
+		dsl, _, err := e.Convert(sql)
+		return dsl, err
+	}
+}
+
+// convertSearch converts a sql to the header and the body of an _msearch by e, so that the indices,
+// routing and body of the request are compared by testFeatureCases
+func convertSearch(e *ESql) func(sql string) (string, error) {
+	return func(sql string) (string, error) {
+		request, err := e.ConvertRequest(sql)
+		if err != nil {
+			return "", err
+		}
+		return msearchBody([]*SearchRequest{request})
+	}
+}
+
 // func testBenchmark(t *testing.T, choice string, round int) {
 // 	var e ESql
 // 	e.SetDefault()
@@ -514,3 +583,902 @@ func readQueries(fileName string) ([]string, error) {
 // 	fmt.Println("Compare performance dsl processing in ES server between esql and elasticsql  ... ")
 // 	testBenchmark(t, "search", 100)
 // }
+
+func TestPreprocess(t *testing.T) {
+	cases := [][]string{
+		{`SELECT COUNT(*) FROM test1 GROUP BY GROUPING SETS ((colA, colB), (colA), ())`,
+			`SELECT COUNT(*) FROM test1 GROUP BY grouping_sets((colA, colB), (colA), (null))`},
+		{`SELECT * FROM test1 WHERE colA = 'GROUPING SETS ()'`,
+			`SELECT * FROM test1 WHERE colA = 'GROUPING SETS ()'`},
+		{`SELECT COUNT(*) FILTER (WHERE colB = 'failed'), AVG(colE) filter(where colD > 1) FROM test1 GROUP BY colA`,
+			`SELECT aggregate_filter(COUNT(*), colB = 'failed'), aggregate_filter(AVG(colE), colD > 1) FROM test1 GROUP BY colA`},
+		{`SELECT colA FROM test1 WHERE colB = 'x FILTER (WHERE y)'`,
+			`SELECT colA FROM test1 WHERE colB = 'x FILTER (WHERE y)'`},
+		{`SELECT * FROM test1 WHERE EXTRACT(YEAR FROM colA) = 2020 AND extract ( Month from colB ) = 1`,
+			`SELECT * FROM test1 WHERE extract('year', colA) = 2020 AND extract('month', colB ) = 1`},
+		{`SELECT * FROM test1 WHERE colA ILIKE 'a''b%' AND colB NOT ilike "x"`,
+			`SELECT * FROM test1 WHERE colA LIKE ilike('a''b%') AND colB NOT LIKE ilike("x")`},
+		{`SELECT * FROM test1 WHERE colA LIKE 'a\_b\%\n' AND colB = 'ILIKE \_'`,
+			`SELECT * FROM test1 WHERE colA LIKE 'a\\_b\\%\n' AND colB = 'ILIKE \\_'`},
+		{`SELECT * FROM test1 WHERE colA NOT IN cidr ('10.0.0.0/8', '::1/128') AND colB = 'IN CIDR('`,
+			`SELECT * FROM test1 WHERE colA NOT IN (cidr('10.0.0.0/8', '::1/128')) AND colB = 'IN CIDR('`},
+		{"SELECT * FROM logs-*, `a-b` x,logs-2020.01 WHERE colA = 'FROM a-b'",
+			"SELECT * FROM `logs-*`, `a-b` x,`logs-2020.01` WHERE colA = 'FROM a-b'"},
+	}
+	for i, c := range cases {
+		sql, err := preprocess(c[0])
+		if err != nil {
+			t.Errorf("%vth case fails: %v", i+1, err)
+			continue
+		}
+		if sql != c[1] {
+			t.Errorf("%vth case expects %v, got %v", i+1, c[1], sql)
+		}
+	}
+	invalidCases := []string{
+		`SELECT COUNT(*) FROM test1 GROUP BY GROUPING SETS ((colA)`,
+		`SELECT colA FILTER (WHERE colB = 1) FROM test1`,
+		`SELECT COUNT(*) FILTER (WHERE colB = 1 FROM test1`,
+		`SELECT * FROM test1 WHERE colA ILIKE colB`,
+	}
+	for i, sql := range invalidCases {
+		if _, err := preprocess(sql); err == nil {
+			t.Errorf("%vth invalid case should fail but not", i+1)
+		}
+	}
+}
+
+func TestDecodeGroupBy(t *testing.T) {
+	response := `{"aggregations": {
+		"groupby": {"buckets": [
+			{"key": {"group_colA": "a", "group_colB": "b"}, "doc_count": 2, "avg_colE": {"value": 1.5}, "grouping_colB": {"value": 0},
+				"count_filter_1": {"doc_count": 1}, "avg_colE_filter_1": {"doc_count": 1, "value": {"value": 1}}}]},
+		"groupby_1": {"buckets": [
+			{"key": {"group_colA": "a"}, "doc_count": 3, "avg_colE": {"value": 2}, "grouping_colB": {"value": 1}}]},
+		"groupby_2": {"buckets": [
+			{"key": {"_total": "total"}, "doc_count": 5, "avg_colE": {"value": 2.5}, "grouping_colB": {"value": 1}}]}}}`
+	rows, err := DecodeGroupBy([]byte(response))
+	if err != nil {
+		t.Errorf("decode fails: %v", err)
+		return
+	}
+	expected := []map[string]interface{}{
+		{"colA": "a", "colB": "b", "_count": 2.0, "avg_colE": 1.5, "grouping_colB": 0.0, "count_filter_1": 1.0, "avg_colE_filter_1": 1.0},
+		{"colA": "a", "colB": nil, "_count": 3.0, "avg_colE": 2.0, "grouping_colB": 1.0},
+		{"colA": nil, "colB": nil, "_count": 5.0, "avg_colE": 2.5, "grouping_colB": 1.0},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("decoded rows do not match: %v", rows)
+	}
+}
+
+func TestExtractTimeZone(t *testing.T) {
+	cases := [][]string{
+		{`SET TIME ZONE 'America/Los_Angeles'; SELECT * FROM test1`, ` SELECT * FROM test1`, `America/Los_Angeles`},
+		{`set time_zone = '+08:00';SELECT * FROM test1`, `SELECT * FROM test1`, `+08:00`},
+		{`SELECT /*+ TIME_ZONE('UTC') */ * FROM test1`, `SELECT  * FROM test1`, `UTC`},
+		{`SELECT * FROM test1 WHERE colA = '/*+ TIME_ZONE(''UTC'') */'`, `SELECT * FROM test1 WHERE colA = '/*+ TIME_ZONE(''UTC'') */'`, ``},
+	}
+	for i, c := range cases {
+		sql, timeZone, err := extractTimeZone(c[0])
+		if err != nil {
+			t.Errorf("%vth case fails: %v", i+1, err)
+			continue
+		}
+		if sql != c[1] || timeZone != c[2] {
+			t.Errorf("%vth case expects %v and %v, got %v and %v", i+1, c[1], c[2], sql, timeZone)
+		}
+	}
+	invalidCases := []string{
+		`SET TIME ZONE '+08:00'; SELECT /*+ TIME_ZONE('UTC') */ * FROM test1`,
+		`SET TIME ZONE 'UTC", "x": "'; SELECT * FROM test1`,
+	}
+	for i, sql := range invalidCases {
+		if _, _, err := extractTimeZone(sql); err == nil {
+			t.Errorf("%vth invalid case should fail but not", i+1)
+		}
+	}
+	e := NewESql()
+	if err := e.SetTimeZone("Asia/Shanghai"); err != nil {
+		t.Errorf("SetTimeZone fails: %v", err)
+	}
+	if err := e.SetTimeZone(`'`); err == nil {
+		t.Errorf("SetTimeZone should fail on invalid time zone")
+	}
+}
+
+func TestSearchAfterValue(t *testing.T) {
+	cases := []struct {
+		value    interface{}
+		expected string
+	}{
+		{int64(1561678568048000000), `1561678568048000000`},
+		{1.5, `1.5`},
+		{true, `true`},
+		{nil, `null`},
+		{`a"b`, `"a\"b"`},
+		{time.Date(2019, 6, 27, 0, 0, 0, 0, time.UTC), `1561593600000`},
+	}
+	for i, c := range cases {
+		value, err := searchAfterValue(c.value)
+		if err != nil {
+			t.Errorf("%vth case fails: %v", i+1, err)
+			continue
+		}
+		if value != c.expected {
+			t.Errorf("%vth case expects %v, got %v", i+1, c.expected, value)
+		}
+	}
+}
+
+const testMapping = `{
+		"test1": {"mappings": {"_doc": {"properties": {
+			"colA": {"type": "text", "fields": {"keyword": {"type": "keyword", "ignore_above": 256}}},
+			"colB": {"type": "text"},
+			"colD": {"type": "long"},
+			"colF": {"type": "boolean"},
+			"colT": {"type": "date"},
+			"colI": {"type": "ip"},
+			"address": {"properties": {"city": {"type": "keyword"}}}}}}},
+		"test2": {"mappings": {"properties": {"colE": {"type": "double"}}}}
+	}`
+
+func TestMappingSchema(t *testing.T) {
+	schema, err := NewMappingSchema([]byte(testMapping))
+	if err != nil {
+		t.Fatalf("NewMappingSchema fails: %v", err)
+	}
+	if m, exist := schema.FieldMapping("test1", "address.city"); !exist || m.Type != "keyword" {
+		t.Errorf("address.city expects keyword, got %v", m)
+	}
+	if m, exist := schema.FieldMapping("alias", "colE"); !exist || m.Type != "double" {
+		t.Errorf("colE expects double, got %v", m)
+	}
+
+	e := NewESql()
+	e.SetSchema(schema)
+	e.index = "test1"
+	if field, exact := e.keywordField("colA"); field != "colA.keyword" || !exact {
+		t.Errorf("colA expects colA.keyword, got %v", field)
+	}
+	if _, exact := e.keywordField("colB"); exact {
+		t.Errorf("colB should not have exact field")
+	}
+	if field, err := e.aggField("group by", "colA"); err != nil || field != "colA.keyword" {
+		t.Errorf("group by colA expects colA.keyword, got %v, %v", field, err)
+	}
+	for _, c := range [][]string{{"avg", "colA"}, {"count", "colB"}} {
+		if _, err := e.aggField(c[0], c[1]); err == nil {
+			t.Errorf("%v on %v should fail but not", c[0], c[1])
+		}
+	}
+	coerceCases := []struct {
+		colName  string
+		lit      literal
+		expected literal
+	}{
+		{"colD", literal{literalString, "10"}, literal{literalInt, "10"}},
+		{"colF", literal{literalString, "true"}, literal{literalBool, "true"}},
+		{"colA", literal{literalInt, "10"}, literal{literalString, "10"}},
+		{"colX", literal{literalString, "10"}, literal{literalString, "10"}},
+	}
+	for i, c := range coerceCases {
+		lit, err := e.coerceLiteral(c.colName, c.lit)
+		if err != nil || lit != c.expected {
+			t.Errorf("%vth coerce case expects %v, got %v, %v", i+1, c.expected, lit, err)
+		}
+	}
+	if _, err := e.coerceLiteral("colD", literal{literalString, "abc"}); err == nil {
+		t.Errorf("coerce abc to long should fail but not")
+	}
+	dateCases := []struct {
+		colName string
+		val     string
+		format  string
+		isDate  bool
+	}{
+		{"colT", "2020-01-01", "yyyy-MM-dd", true},
+		{"colT", "2020/01/01", "", true},
+		{"address.city", "2020-01-01", "yyyy-MM-dd", false},
+		{"colX", "2020-01-01 10:00:00", "yyyy-MM-dd HH:mm:ss", true},
+		{"colX", "abc", "", false},
+	}
+	for i, c := range dateCases {
+		format, isDate := e.dateFieldFormat(c.colName, literal{literalString, c.val})
+		if isDate != c.isDate || (isDate && format != c.format) {
+			t.Errorf("%vth date field case expects %v %v, got %v %v", i+1, c.format, c.isDate, format, isDate)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema, err := NewMappingSchema([]byte(testMapping))
+	if err != nil {
+		t.Fatalf("NewMappingSchema fails: %v", err)
+	}
+	e := NewESql()
+	e.SetSchema(schema)
+	if err := e.Validate(`SELECT COUNT(*) FROM test1 WHERE colA = 'a' AND colD > '10' GROUP BY colA`); err != nil {
+		t.Errorf("valid query fails: %v", err)
+	}
+	err = e.Validate(`SELECT * FROM test1 WHERE colX = 1 AND colD LIKE '1%' AND colB REGEXP 'a.*' AND colF = 'yes'`)
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("expects ValidationErrors, got %v", err)
+	}
+	columns := make(map[string]bool)
+	for _, err := range errs {
+		columns[err.Column] = true
+	}
+	for _, col := range []string{"colX", "colD", "colB", "colF"} {
+		if !columns[col] {
+			t.Errorf("expects an error on %v, got %v", col, errs)
+		}
+	}
+	if err := e.Validate(`SELECT SUM(colA) FROM test1`); err == nil {
+		t.Errorf("SUM on text should fail but not")
+	}
+}
+
+func TestError(t *testing.T) {
+	sql := "SELECT colA FROM test1 WHERE `colB` = 'x y' AND colC   IS   NOT TRUE"
+	for fragment, expected := range map[string]int{
+		"colA":             7,
+		"colB = 'x y'":     29,
+		`colC is not true`: 48,
+		`colB = "x y"`:     29,
+		"colD":             -1,
+		"":                 -1,
+	} {
+		if pos := locateFragment(sql, fragment); pos != expected {
+			t.Errorf("locateFragment(%q) returns %v, expected %v", fragment, pos, expected)
+		}
+	}
+
+	err := locateError(sql, errorf(ErrUnsupported, "colC is not true", "esql: unsupported"))
+	esqlErr, ok := err.(*Error)
+	if !ok || esqlErr.Code != ErrUnsupported || esqlErr.Position != 48 {
+		t.Errorf("locateError returns %#v", err)
+	}
+	if err.Error() != "esql: unsupported at position 48" {
+		t.Errorf("unexpected message %v", err.Error())
+	}
+
+	parseErr := wrapParseError(fmt.Errorf("syntax error at position 20 near 'frm'"))
+	if parseErr.Code != ErrSyntax || parseErr.Fragment != "frm" || parseErr.Position != 17 || parseErr.Message != "esql: syntax error" {
+		t.Errorf("wrapParseError returns %#v", parseErr)
+	}
+
+	macroErr := fmt.Errorf("macro fails")
+	err = wrapError(ErrMacro, "colA", macroErr)
+	if esqlErr, ok := err.(*Error); !ok || esqlErr.Code != ErrMacro || esqlErr.Unwrap() != macroErr {
+		t.Errorf("wrapError returns %#v", err)
+	}
+	if wrapError(ErrMacro, "colA", esqlErr) != error(esqlErr) {
+		t.Errorf("wrapError should keep *Error")
+	}
+}
+
+func TestExplanation(t *testing.T) {
+	var x *Explanation
+	// no-op when the query is not being explained
+	x.addPredicate(&sqlparser.ColName{}, false, `{"term": {"colA": 1}}`)
+
+	x = &Explanation{}
+	for dsl, expected := range map[string]string{
+		`{"term": {"colA": 1}}`:                                                            "term",
+		`{"bool": {"must_not": {"range": {"colA": {"gt": 1}}}}}`:                           "NOT range",
+		`{"bool": {"filter": {"script": {"script": {"source": "1"}}}}}`:                    "script",
+		`{"bool": {"should": [{"match_phrase": {"colA": "a"}}, {"term": {"colA": "b"}}]}}`: "match_phrase OR term",
+	} {
+		var query map[string]interface{}
+		if err := json.Unmarshal([]byte(dsl), &query); err != nil {
+			t.Fatalf("invalid test dsl %v", dsl)
+		}
+		if kind := queryKind(query); kind != expected {
+			t.Errorf("queryKind(%v) returns %v, expected %v", dsl, kind, expected)
+		}
+	}
+
+	x.addPredicate(&sqlparser.ColName{}, false, `{"wildcard": {"colA": {"wildcard": "*a"}}}`)
+	x.addPredicate(&sqlparser.ColName{}, true, `{"bool": {"filter": {"script": {"script": {"source": "1"}}}}}`)
+	x.addPredicate(&sqlparser.ColName{}, false, `{"wildcard": {"colA": {"wildcard": "a*"}}}`)
+	if len(x.Predicates) != 3 || len(x.Warnings) != 2 {
+		t.Errorf("expects 3 predicates and 2 warnings, got %v", x)
+	}
+
+	var dslMap map[string]interface{}
+	dsl := `{"aggs": {"groupby": {"composite": {"size": 1000, "sources": [{"group_colA": {"terms": {"field": "colA"}}}]}, "aggs": {"max_colB": {"max": {"field": "colB"}}}}}}`
+	if err := json.Unmarshal([]byte(dsl), &dslMap); err != nil {
+		t.Fatalf("invalid test dsl %v", dsl)
+	}
+	x = &Explanation{DSL: dsl}
+	x.addAggregations("", dslMap["aggs"].(map[string]interface{}), 1000)
+	expected := []PlanAggregation{
+		{Tag: "groupby", Type: "composite", Fields: []string{"colA"}},
+		{Tag: "groupby>max_colB", Type: "max", Fields: []string{"colB"}},
+	}
+	if !reflect.DeepEqual(x.Aggregations, expected) {
+		t.Errorf("addAggregations returns %v, expected %v", x.Aggregations, expected)
+	}
+	if len(x.Warnings) != 1 || !strings.Contains(x.String(), "groupby>max_colB: max(colB)") {
+		t.Errorf("unexpected plan %v", x)
+	}
+}
+
+func parseWhere(t *testing.T, cond string) sqlparser.Expr {
+	stmt, err := sqlparser.Parse("SELECT * FROM test1 WHERE " + cond)
+	if err != nil {
+		t.Fatalf("fail to parse %v: %v", cond, err)
+	}
+	return stmt.(*sqlparser.Select).Where.Expr
+}
+
+func joinExprs(exprs []sqlparser.Expr, sep string) string {
+	var strs []string
+	for _, expr := range exprs {
+		strs = append(strs, sqlparser.String(expr))
+	}
+	return strings.Join(strs, sep)
+}
+
+func TestOptimize(t *testing.T) {
+	schema, err := NewMappingSchema([]byte(testMapping))
+	if err != nil {
+		t.Fatalf("NewMappingSchema fails: %v", err)
+	}
+	e := NewESql()
+	e.SetSchema(schema)
+	e.index = "test1"
+
+	// mergeRanges gets the conjuncts of AND, FALSE stands for a contradiction
+	rangeCases := []struct {
+		cond     string
+		expected string
+	}{
+		{`colD > 1 and colD >= 3 and colD < 10`, `colD between right open 3 and 10`},
+		{`colD >= 1 and colD <= 1`, `colD between 1 and 1`},
+		{`colD > 1 and colD < 1`, `false`},
+		{`colD = 1 and colD = 1.0`, `colD = 1.0`},
+		{`colD = 1 and colD = 2`, `false`},
+		{`colD = 5 and colD > 1 and colD != 3`, `colD = 5`},
+		{`colD = 5 and colD != 5`, `false`},
+		{`colD = 5 and colD > 5`, `false`},
+		{`address.city = 'a' and address.city = 'b'`, `false`},
+		{`address.city = 'a' and address.city != 'b'`, `address.city = 'a'`},
+		{`colT = '2020-01-01' and colT = '2020-01-01T00:00:00'`, `colT = '2020-01-01' and colT = '2020-01-01T00:00:00'`},
+		{`colT = '2020-01-01' and colT != '2020-01-01T00:00:00'`, `colT = '2020-01-01' and colT != '2020-01-01T00:00:00'`},
+		{`colT = 1577836800000 and colT = '2020-01-01'`, `colT = 1577836800000 and colT = '2020-01-01'`},
+		{`colI = '::1' and colI = '0:0::1'`, `colI = '::1' and colI = '0:0::1'`},
+		{`colI = '10.0.0.1' and colI = '10.0.0.01'`, `colI = '10.0.0.1' and colI = '10.0.0.01'`},
+		{`colA = 'a' and colA = 'b'`, `colA = 'a' and colA = 'b'`},
+		{`colX = 'a' and colX = 'b'`, `colX = 'a' and colX = 'b'`},
+		{`colX > 1 and colX < 5`, `colX between open 1 and 5`},
+	}
+	for i, c := range rangeCases {
+		result, _, contradiction := e.mergeRanges(flattenAnd(parseWhere(t, c.cond)))
+		actual := joinExprs(result, " and ")
+		if contradiction {
+			actual = "false"
+		}
+		if actual != c.expected {
+			t.Errorf("%vth merge ranges case expects %v, got %v", i+1, c.expected, actual)
+		}
+	}
+
+	// mergeEqualities gets the disjuncts of OR
+	equalityCases := []struct {
+		cond     string
+		expected string
+	}{
+		{`colD = 1 or colD = 2 or colD in (2, 3)`, `colD in (1, 2, 3)`},
+		{`colD = 1 or colE = 2`, `colD = 1 or colE = 2`},
+		{`colD = 1 or colE = 2 or colD = 3`, `colD in (1, 3) or colE = 2`},
+		{`colD = 1 or colD > 2`, `colD = 1 or colD > 2`},
+		{`colD = null or colD = 1`, `colD = null or colD = 1`},
+		{`colT = '2020-01-01' or colT = '2020-01-02'`, `colT in ('2020-01-01', '2020-01-02')`},
+	}
+	for i, c := range equalityCases {
+		var disjuncts []sqlparser.Expr
+		var flatten func(expr sqlparser.Expr)
+		flatten = func(expr sqlparser.Expr) {
+			if orExpr, ok := expr.(*sqlparser.OrExpr); ok {
+				flatten(orExpr.Left)
+				flatten(orExpr.Right)
+				return
+			}
+			disjuncts = append(disjuncts, expr)
+		}
+		flatten(parseWhere(t, c.cond))
+		result, _ := e.mergeEqualities(disjuncts)
+		if actual := joinExprs(result, " or "); actual != c.expected {
+			t.Errorf("%vth merge equalities case expects %v, got %v", i+1, c.expected, actual)
+		}
+	}
+
+	// isolateColumn gets lhs op rhs of a comparison, an empty expectation means it can not isolate
+	isolateCases := []struct {
+		cond     string
+		expected string
+	}{
+		{`2 * colD + 1 > 9`, `colD > 4`},
+		{`10 - colD <= 4`, `colD >= 6`},
+		{`-colD = 3`, `colD = -3`},
+		{`colD * -2 < 4`, `colD > -2`},
+		{`colD / 2 > 1`, ``},
+		{`colD * 0 > 1`, ``},
+		{`colD * 3 = 10`, ``},
+		{`colD * 3.0 = 10`, `colD = 3.3333333333333335`},
+		{`colD + colE > 1`, ``},
+	}
+	for i, c := range isolateCases {
+		comparisonExpr := parseWhere(t, c.cond).(*sqlparser.ComparisonExpr)
+		colExpr, op, val, ok := e.isolateColumn(comparisonExpr.Left, comparisonExpr.Operator, comparisonExpr.Right)
+		actual := ""
+		if ok {
+			actual = sqlparser.String(&sqlparser.ComparisonExpr{Operator: op, Left: colExpr, Right: val})
+		}
+		if actual != c.expected {
+			t.Errorf("%vth isolate column case expects %v, got %v", i+1, c.expected, actual)
+		}
+	}
+
+	foldCases := []struct {
+		cond     string
+		expected string
+	}{
+		{`colD > 8 + 2 * 3`, `14`},
+		{`colD > (1 + 2) * 1.5`, `4.5`},
+		{`colD > 7 / 2`, `3.5`},
+		{`colD > 1 / 0`, `1 / 0`},
+		{`colD > colE + (1 + 1)`, `colE + 2`},
+		{`colD > '1' + 1`, `'1' + 1`},
+	}
+	for i, c := range foldCases {
+		folded, _ := foldConstant(parseWhere(t, c.cond).(*sqlparser.ComparisonExpr).Right)
+		if actual := sqlparser.String(folded); actual != c.expected {
+			t.Errorf("%vth fold constant case expects %v, got %v", i+1, c.expected, actual)
+		}
+	}
+
+	// Explain shows WHERE as it is rewritten, and nothing if it is not
+	for sql, expected := range map[string]string{
+		`SELECT * FROM test1 WHERE colD > 1 AND colD > 2`: `colD > 2`,
+		`SELECT * FROM test1 WHERE colD > 1 OR colE > 2`:  ``,
+	} {
+		x, err := e.Explain(sql)
+		if err != nil || x.Where != expected || strings.Contains(x.String(), "Optimized WHERE") != (expected != "") {
+			t.Errorf("explained WHERE of %v expects %v, got %v, %v", sql, expected, x, err)
+		}
+	}
+}
+
+func TestLegacyScript(t *testing.T) {
+	e := NewESql()
+	e.SetLegacyScript(true)
+	cases := []struct {
+		sql      string
+		expected string
+	}{
+		{`SELECT * FROM test1 WHERE colB + 'c' = colA`, `doc['colB'].value + 'c' == doc['colA'].value`},
+		{`SELECT * FROM test1 WHERE (colD + colE)*(colD / colE) > 2`, `(doc['colD'].value + doc['colE'].value) * (doc['colD'].value / doc['colE'].value) > 2`},
+	}
+	for i, c := range cases {
+		dsl, _, err := e.Convert(c.sql)
+		if err != nil {
+			t.Errorf("%vth legacy script case fails: %v", i+1, err)
+			continue
+		}
+		expected := fmt.Sprintf(`{"bool": {"filter": {"script": {"script": {"source": "%v"}}}}}`, c.expected)
+		if !strings.Contains(dsl, expected) {
+			t.Errorf("%vth legacy script case expects %v, got %v", i+1, expected, dsl)
+		}
+	}
+}
+
+func TestNowParam(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+	e := NewESql()
+	dsl, _, err := e.Convert(`SELECT * FROM test1 WHERE DATE_ADD(ExecutionTime, INTERVAL 1 DAY) > NOW()`)
+	if err != nil {
+		t.Fatalf("now param case fails: %v", err)
+	}
+	// the source stays the same as time goes, only the param changes
+	source := `doc['ExecutionTime'].size() != 0 && ZonedDateTime.ofInstant(Instant.ofEpochMilli(doc['ExecutionTime'].value.getMillis()), ZoneOffset.UTC).plus((long) (params.p0), ChronoUnit.DAYS).toInstant().toEpochMilli() > params.p1`
+	expected := fmt.Sprintf(`{"query": {"bool": {"filter": {"script": {"script": {"source": "%v", "params": {"p0": 1, "p1": 1577836800000}}}}}}, "size": 1000}`, source)
+	var dslMap, expectedMap map[string]interface{}
+	if err = json.Unmarshal([]byte(dsl), &dslMap); err != nil {
+		t.Fatalf("now param case fails: %v", err)
+	}
+	if err = json.Unmarshal([]byte(expected), &expectedMap); err != nil {
+		t.Fatalf("now param case reference fails: %v", err)
+	}
+	if !reflect.DeepEqual(dslMap, expectedMap) {
+		t.Errorf("now param case expects %v, got %v", expected, dsl)
+	}
+}
+
+func TestDateLiteralParam(t *testing.T) {
+	e := NewESql()
+	truncated := `ZonedDateTime.ofInstant(Instant.ofEpochMilli(doc['ExecutionTime'].value.getMillis()), ZoneOffset.UTC).truncatedTo(ChronoUnit.DAYS).toInstant().toEpochMilli()`
+	for _, op := range []string{"=", "!=", "<", "<=", ">", ">="} {
+		// a date literal is compared in millis, painless can not compare it as a string
+		dsl, _, err := e.Convert(fmt.Sprintf(`SELECT * FROM test1 WHERE DATE_TRUNC('day', ExecutionTime) %v '2020-01-01'`, op))
+		if err != nil {
+			t.Errorf("date literal case of %v fails: %v", op, err)
+			continue
+		}
+		source := fmt.Sprintf(`doc['ExecutionTime'].size() != 0 && %v %v params.p0`, truncated, op2PainlessOp[op])
+		expected := fmt.Sprintf(`{"query": {"bool": {"filter": {"script": {"script": {"source": "%v", "params": {"p0": 1577836800000}}}}}}, "size": 1000}`, source)
+		var dslMap, expectedMap map[string]interface{}
+		if err = json.Unmarshal([]byte(dsl), &dslMap); err != nil {
+			t.Errorf("date literal case of %v fails: %v", op, err)
+			continue
+		}
+		if err = json.Unmarshal([]byte(expected), &expectedMap); err != nil {
+			t.Fatalf("date literal case reference of %v fails: %v", op, err)
+		}
+		if !reflect.DeepEqual(dslMap, expectedMap) {
+			t.Errorf("date literal case of %v expects %v, got %v", op, expected, dsl)
+		}
+	}
+	// the literal is in the time zone of the query
+	e.SetTimeZone("+08:00")
+	dsl, _, err := e.Convert(`SELECT * FROM test1 WHERE DATE_ADD(ExecutionTime, INTERVAL 1 DAY) > '2020-01-01 08:00:00'`)
+	if err != nil || !strings.Contains(dsl, `"p1": 1577836800000`) {
+		t.Errorf("date literal case in time zone expects param 1577836800000, got %v, %v", dsl, err)
+	}
+	if _, _, err = e.Convert(`SELECT * FROM test1 WHERE DATE_ADD(ExecutionTime, INTERVAL 1 DAY) > 'tomorrow'`); err == nil {
+		t.Errorf("date literal case of a string not in a date format expects error")
+	}
+}
+
+// ILIKE is case_insensitive of es 7.10, so its cases are not searched by TestSQL, whose es is older
+func TestILike(t *testing.T) {
+	testFeatureCases(t, "ILike", convertDsl(NewESql()))
+}
+
+func TestStrictNull(t *testing.T) {
+	e := NewESql()
+	e.SetStrictNull(true)
+	e.SetCountNulls(true)
+	testFeatureCases(t, "StrictNull", convertDsl(e))
+}
+
+var testNestedMapping = `{
+		"orders": {"mappings": {"properties": {
+			"status": {"type": "keyword"},
+			"total": {"type": "double"},
+			"items": {"type": "nested", "properties": {
+				"sku": {"type": "keyword"},
+				"qty": {"type": "long"},
+				"parts": {"type": "nested", "properties": {"id": {"type": "long"}}}}}}}}
+	}`
+
+func TestNested(t *testing.T) {
+	schema, err := NewMappingSchema([]byte(testNestedMapping))
+	if err != nil {
+		t.Fatalf("NewMappingSchema fails: %v", err)
+	}
+	e := NewESql()
+	e.SetSchema(schema)
+	testFeatureCases(t, "Nested", convertDsl(e))
+	if _, _, err := e.Convert(`SELECT COUNT(*) FROM orders GROUP BY status, items.sku`); err == nil {
+		t.Errorf("GROUP BY columns of root and nested objects should fail but not")
+	}
+
+	response := `{"aggregations": {"nested": {"doc_count": 3, "groupby": {"buckets": [
+		{"key": {"group_items.sku": "x"}, "doc_count": 2, "max_total": {"doc_count": 1, "value": {"value": 20}}}]}}}}`
+	rows, err := DecodeGroupBy([]byte(response))
+	expectedRows := []map[string]interface{}{{"items.sku": "x", "_count": 2.0, "max_total": 20.0}}
+	if err != nil || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("decoded nested rows do not match: %v, %v", rows, err)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	e := NewESql()
+	testFeatureCases(t, "Join", convertDsl(e))
+	for _, sql := range []string{
+		`SELECT * FROM qa WHERE HAS_CHILD(answer, votes > 10)`,
+		`SELECT * FROM qa WHERE HAS_PARENT('question')`,
+	} {
+		if _, _, err := e.Convert(sql); err == nil {
+			t.Errorf("%v should fail but not", sql)
+		}
+	}
+
+	// inner_hits are named uniquely, and not returned under NOT
+	e.SetInnerHits(true)
+	testFeatureCases(t, "JoinInnerHits", convertDsl(e))
+
+	response := `{"hits": {"hits": [{"_id": "1", "_source": {"title": "q"}, "inner_hits": {"answer": {"hits": {"hits": [
+		{"_id": "2", "_source": {"votes": 11}}]}}}}]}}`
+	rows, err := DecodeHits([]byte(response))
+	expectedRows := []map[string]interface{}{{"title": "q", "_id": "1", "_inner_hits": map[string][]map[string]interface{}{
+		"answer": {{"votes": 11.0, "_id": "2"}}}}}
+	if err != nil || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("decoded hits do not match: %v, %v", rows, err)
+	}
+}
+
+func TestGeo(t *testing.T) {
+	e := NewESql()
+	testFeatureCases(t, "Geo", convertDsl(e))
+	for _, sql := range []string{
+		`SELECT * FROM shops WHERE GEO_DISTANCE(location, 40.7, -74) = '10km'`,
+		`SELECT * FROM shops WHERE GEO_BOUNDING_BOX(location, 41, -75)`,
+		`SELECT * FROM shops WHERE GEO_POLYGON(location, 40, -70, 30, -80)`,
+		`SELECT * FROM shops WHERE GEO_DISTANCE(location, 'a', -74) < 10`,
+		`SELECT * FROM shops WHERE GEO_DISTANCE(location, 40.7, -74) < '10 parsecs'`,
+		`SELECT city, COUNT(*) FROM shops GROUP BY city ORDER BY GEO_DISTANCE(location, 40.7, -74)`,
+		`SELECT COUNT(*) FROM shops GROUP BY city, GEOHASH_GRID(location, 5)`,
+	} {
+		if _, _, err := e.Convert(sql); err == nil {
+			t.Errorf("%v should fail but not", sql)
+		}
+	}
+
+	response := `{"aggregations": {"groupby": {"meta": {"key": "geohash_grid_location"}, "buckets": [
+		{"key": "dr5ru", "doc_count": 2, "avg_rating": {"value": 4.5}}]}}}`
+	rows, err := DecodeGroupBy([]byte(response))
+	expectedRows := []map[string]interface{}{{"geohash_grid_location": "dr5ru", "_count": 2.0, "avg_rating": 4.5}}
+	if err != nil || !reflect.DeepEqual(rows, expectedRows) {
+		t.Errorf("decoded geohash rows do not match: %v, %v", rows, err)
+	}
+}
+
+var testIPMapping = `{
+		"logs": {"mappings": {"properties": {
+			"ip": {"type": "ip"},
+			"host": {"type": "keyword"}}}}
+	}`
+
+func TestIP(t *testing.T) {
+	schema, err := NewMappingSchema([]byte(testIPMapping))
+	if err != nil {
+		t.Fatalf("NewMappingSchema fails: %v", err)
+	}
+	e := NewESql()
+	e.SetSchema(schema)
+	// a macro that transforms every value, which must not reach ip addresses
+	e.ProcessQueryValue(func(colName string) bool { return true }, func(value string) (string, error) {
+		if value == "localhost" {
+			return "::1", nil
+		}
+		return strings.ToUpper(strings.Replace(value, ":", "-", -1)), nil
+	})
+	testFeatureCases(t, "IP", convertDsl(e))
+	for _, sql := range []string{
+		`SELECT * FROM logs WHERE ip IN CIDR('10.0.0.0')`,
+		`SELECT * FROM logs WHERE ip = 'remotehost'`,
+		`SELECT * FROM logs WHERE CIDR_MATCH(host, '10.0.0.0/8')`,
+		`SELECT IP_RANGE(ip, '10.0.0.0', '10.0.0.0/8') FROM logs`,
+		`SELECT IP_RANGE(host, '10.0.0.0/8') FROM logs`,
+	} {
+		if _, _, err := e.Convert(sql); err == nil {
+			t.Errorf("%v should fail but not", sql)
+		}
+	}
+}
+
+func TestConvertRequest(t *testing.T) {
+	e := NewESql()
+	dsl, _, err := e.Convert(`SELECT * FROM wf WHERE _id IN ('a', 'b') OR _id != 'c'`)
+	expected := `{"bool": {"should": [{"ids": {"values": ["a", "b"]}},{"bool": {"must_not": {"ids": {"values": ["c"]}}}}]}}`
+	if err != nil || !strings.Contains(dsl, expected) {
+		t.Errorf("_id expects %v, got %v, %v", expected, dsl, err)
+	}
+
+	cases := []struct {
+		sql      string
+		path     string
+		expected string
+	}{
+		{`SELECT * FROM wf WHERE _id = 'a' AND _routing = 'd1'`,
+			`/wf/_search?routing=d1`, `{"bool": {"filter": [{"ids": {"values": ["a"]}},{"term": {"_routing": "d1"}}]}}`},
+		{`SELECT * FROM wf-* WHERE _index IN ('wf-1', 'wf-2') AND _routing IN ('r1', 'r 2') AND status = 1`,
+			`/wf-1,wf-2/_search?routing=r1,r+2`, `"query": {"bool": {"filter": [{"terms": {"_routing": ["r1", "r 2"]}},{"term": {"status": 1}}]}}`},
+		{`SELECT * FROM wf-1 WHERE _index = 'wf-1'`,
+			`/wf-1/_search`, `{"size": 1000}`},
+		{`SELECT * FROM wf-* WHERE _index IN ('wf-1', 'other')`,
+			`/wf-1/_search`, `{"size": 1000}`},
+		// indices other than FROM are not searched
+		{`SELECT * FROM wf WHERE _index = 'other'`,
+			`/wf/_search`, `{"term": {"_index": "other"}}`},
+		{`SELECT * FROM wf WHERE _routing = 'r1' OR status = 1`,
+			`/wf/_search`, `{"bool": {"should": [{"term": {"_routing": "r1"}},{"term": {"status": 1}}]}}`},
+	}
+	for i, c := range cases {
+		request, err := e.ConvertRequest(c.sql)
+		if err != nil {
+			t.Errorf("%vth request case fails: %v", i+1, err)
+			continue
+		}
+		if request.Path() != c.path || !strings.Contains(request.Body, c.expected) {
+			t.Errorf("%vth request case expects %v %v, got %v %v", i+1, c.path, c.expected, request.Path(), request.Body)
+		}
+	}
+}
+
+func TestFromTargets(t *testing.T) {
+	schema, err := NewMappingSchema([]byte(`{
+		"logs-1": {"mappings": {"properties": {"status": {"type": "keyword"}}}},
+		"metrics": {"mappings": {"properties": {"status": {"type": "long"}}}}
+	}`))
+	if err != nil {
+		t.Fatalf("NewMappingSchema fails: %v", err)
+	}
+	e := NewESql()
+	e.SetSchema(schema)
+	e.SetIndexMapping(func(table string) ([]string, error) {
+		if table == "workflows" {
+			return []string{"workflows-v1", "workflows-v2"}, nil
+		}
+		return nil, nil
+	})
+	testFeatureCases(t, "FromTargets", convertSearch(e))
+	for _, sql := range []string{
+		`SELECT * FROM logs JOIN metrics ON logs.id = metrics.id`,
+		`SELECT * FROM (SELECT * FROM logs) l`,
+	} {
+		if _, err := e.ConvertRequest(sql); err == nil {
+			t.Errorf("%v should fail but not", sql)
+		}
+	}
+}
+
+func TestTimeIndices(t *testing.T) {
+	now := time.Date(2019, 6, 30, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	e := NewESql()
+	e.SetTimeIndices(
+		TimeIndex{Pattern: "events-*", Layout: "events-2006.01.02", TimeColumn: "ts", MaxIndices: 5},
+		TimeIndex{Pattern: "hourly-*", Layout: "hourly-2006.01.02.15", TimeColumn: "ts"},
+	)
+	// a range w/o lower bound, more indices than MaxIndices, or _index in WHERE, are searched as they are
+	// w/o ignore_unavailable
+	testFeatureCases(t, "TimeIndices", convertSearch(e))
+}
+
+func TestUnion(t *testing.T) {
+	e := NewESql()
+	testFeatureCases(t, "Union", func(sql string) (string, error) {
+		request, err := e.ConvertUnion(sql)
+		if err != nil {
+			return "", err
+		}
+		return request.Body()
+	})
+
+	request, err := e.ConvertUnion(`SELECT * FROM logs-* WHERE status = 1 UNION ALL (SELECT * FROM archive WHERE _routing = 'r1' LIMIT 5) ORDER BY ts DESC LIMIT 10 OFFSET 2`)
+	if err != nil || request.Path() != "/_msearch" {
+		t.Fatalf("ConvertUnion fails: %v", err)
+	}
+	response := `{"responses": [
+		{"hits": {"hits": [{"_id": "1", "_source": {"ts": 5}}, {"_id": "2", "_source": {"ts": 3}}, {"_id": "3", "_source": {}}]}},
+		{"hits": {"hits": [{"_id": "4", "_source": {"ts": 4}}, {"_id": "5", "_source": {"ts": 1}}, {"_id": "6", "_source": {"ts": 6}}]}}
+	]}`
+	rows, err := request.Decode([]byte(response))
+	var ids []interface{}
+	for _, row := range rows {
+		ids = append(ids, row["_id"])
+	}
+	if err != nil || !reflect.DeepEqual(ids, []interface{}{"4", "2", "5", "3"}) {
+		t.Errorf("union decode expects [4 2 5 3], got %v, %v", ids, err)
+	}
+	if _, err = request.Decode([]byte(`{"responses": [{"hits": {"hits": []}}, {"error": {"type": "index_not_found_exception"}, "status": 404}]}`)); err == nil {
+		t.Errorf("union decode should fail on a failed branch but not")
+	}
+
+	for _, sql := range []string{
+		`SELECT * FROM logs UNION SELECT * FROM archive`,
+		`SELECT * FROM logs UNION ALL SELECT * FROM archive ORDER BY ts + 1`,
+		`SELECT * FROM logs UNION ALL SELECT * FROM archive LIMIT ?`,
+		`SELECT COUNT(*) FROM logs UNION ALL SELECT COUNT(*) FROM archive`,
+		`SELECT id, ts FROM logs UNION ALL SELECT id FROM archive`,
+	} {
+		if _, err := e.ConvertUnion(sql); err == nil {
+			t.Errorf("%v should fail but not", sql)
+		}
+	}
+	if _, _, err := e.Convert(`SELECT * FROM logs UNION ALL SELECT * FROM archive`); err == nil {
+		t.Errorf("Convert of UNION ALL should fail but not")
+	}
+}
+
+func TestConvertBatch(t *testing.T) {
+	e := NewESql()
+	batch := e.ConvertBatch([]Statement{
+		{SQL: `SELECT * FROM logs WHERE status = 1 ORDER BY ts`, Pagination: []interface{}{100}},
+		{SQL: `SELECT * FROM logs WHERE`},
+		{SQL: `SELECT status, COUNT(*) FROM logs GROUP BY status`, Index: []string{"logs-1", "logs-2"}},
+	})
+	if batch.Errors[0] != nil || batch.Errors[1] == nil || batch.Errors[2] != nil || batch.Requests[1] != nil {
+		t.Fatalf("batch expects the 2nd statement to fail only, got %v", batch.Errors)
+	}
+	body, err := batch.Body()
+	if err != nil || batch.Path() != "/_msearch" {
+		t.Fatalf("batch body fails: %v", err)
+	}
+	refs, err := readQueries(`testcases/dslRefBatch.txt`)
+	if err != nil {
+		t.Fatalf("Fail to load testcases ref of batch")
+	}
+	if err = compareOutput(body, refs[0]); err != nil {
+		t.Errorf("batch body does not match: %v\n\t%v", err, body)
+	}
+
+	response := `{"responses": [
+		{"hits": {"hits": [{"_id": "1", "_source": {"status": 1}}]}},
+		{"error": {"type": "index_not_found_exception"}, "status": 404}
+	]}`
+	rows, errs, err := batch.Decode([]byte(response))
+	if err != nil || len(rows) != 3 || len(rows[0]) != 1 || rows[0][0]["_id"] != "1" {
+		t.Fatalf("batch decode expects a row of the 1st statement, got %v, %v", rows, err)
+	}
+	if errs[0] != nil || errs[1] != batch.Errors[1] || errs[2] == nil || rows[2] != nil {
+		t.Errorf("batch decode expects errors of the 2nd and 3rd statements, got %v", errs)
+	}
+	if _, _, err = batch.Decode([]byte(`{"responses": [{"hits": {"hits": []}}]}`)); err == nil {
+		t.Errorf("batch decode should fail on missing responses but not")
+	}
+
+	// more statements than workers, and a macro that panics on a value
+	e.ProcessQueryValue(func(colName string) bool { return colName == "bad" }, func(value string) (string, error) { panic(value) })
+	var statements []Statement
+	for i := 0; i < 100; i++ {
+		statements = append(statements, Statement{SQL: fmt.Sprintf(`SELECT * FROM logs WHERE status = %v`, i)})
+	}
+	statements = append(statements, Statement{SQL: `SELECT * FROM logs WHERE bad = 'x'`})
+	batch = e.ConvertBatch(statements)
+	for i := 0; i < 100; i++ {
+		if batch.Errors[i] != nil || batch.Requests[i] == nil {
+			t.Errorf("%vth batch statement fails: %v", i, batch.Errors[i])
+			continue
+		}
+		if err = compareOutput(batch.Requests[i].Body, fmt.Sprintf(`{"query": {"term": {"status": %v}}, "size": 1000}`, i)); err != nil {
+			t.Errorf("%vth batch statement expects its own request: %v", i, err)
+		}
+	}
+	if batch.Errors[100] == nil || batch.Requests[100] != nil {
+		t.Errorf("batch expects a panicking statement to fail, got %v", batch.Requests[100])
+	}
+
+	// aggregations w/o GROUP BY have neither hits nor buckets to decode as rows
+	batch = e.ConvertBatch([]Statement{{SQL: `SELECT AVG(colD) FROM logs`}, {SQL: `SELECT COUNT(*) FROM logs`}, {SQL: `SELECT colB, COUNT(*) FROM logs GROUP BY colB`}})
+	if batch.Errors[0] == nil || batch.Errors[1] == nil || batch.Errors[2] != nil || batch.Requests[0] != nil || batch.Requests[1] != nil {
+		t.Errorf("batch expects aggregations w/o GROUP BY to fail, got %v", batch.Errors)
+	}
+}
+
+func TestViews(t *testing.T) {
+	e := NewESql()
+	if err := e.SetView("failed", `SELECT * FROM workflows WHERE status = 'failed'`); err != nil {
+		t.Fatalf("SetView fails: %v", err)
+	}
+	if err := e.SetView("logs", `SELECT * FROM logs WHERE level = 'error'`); err != nil {
+		t.Fatalf("SetView fails: %v", err)
+	}
+	testFeatureCases(t, "Views", convertSearch(e))
+
+	for _, sql := range []string{
+		`WITH recent AS (SELECT id FROM events WHERE ts > 100) SELECT * FROM recent WHERE status = 1`,
+		`WITH recent AS (SELECT status, COUNT(*) FROM events GROUP BY status) SELECT * FROM recent`,
+		`WITH recent AS (SELECT * FROM events LIMIT 10) SELECT * FROM recent`,
+		`WITH recent AS (SELECT * FROM events), recent AS (SELECT * FROM events) SELECT * FROM recent`,
+		`WITH recent (SELECT * FROM events) SELECT * FROM recent`,
+		`SELECT * FROM failed, other`,
+	} {
+		if _, _, err := e.Convert(sql); err == nil {
+			t.Errorf("%v should fail but not", sql)
+		}
+	}
+	if err := e.SetView("broken", `SELECT * FROM`); err == nil {
+		t.Errorf("SetView of invalid sql should fail but not")
+	}
+}
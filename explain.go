@@ -0,0 +1,314 @@
+package esql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// PlanPredicate ...
+// PlanPredicate is a predicate of WHERE (or of FILTER of an aggregation) and the es query it becomes,
+// e.g. "term", "range", "NOT wildcard", "script"
+type PlanPredicate struct {
+	SQL   string
+	Query string
+}
+
+// PlanAggregation ...
+// PlanAggregation is an aggregation in dsl. Tag is the path of the aggregation, e.g. "groupby>max_colA",
+// Type is the es aggregation type and Fields are the fields or buckets paths it works on
+type PlanAggregation struct {
+	Tag    string
+	Type   string
+	Fields []string
+}
+
+// Explanation ...
+// Explanation is the translation plan of a query returned by Explain. Where is WHERE as the optimizer
+// rewrites it, empty if it is not rewritten
+type Explanation struct {
+	DSL          string
+	SortField    []string
+	Where        string
+	Predicates   []PlanPredicate
+	Aggregations []PlanAggregation
+	Warnings     []string
+}
+
+var explainRegexp = regexp.MustCompile(`(?i)^\s*EXPLAIN\s+`)
+
+// Explain ...
+// Transform sql to elasticsearch dsl, and explain how the query is translated
+//
+// usage:
+//  - explanation, err := e.Explain(sql, pageParam1, pageParam2, ...)
+//
+// arguments:
+//  - sql: the sql query needs conversion in string format, optionally prefixed by EXPLAIN
+//  - pagination: variadic arguments that indicates es search_after
+//
+// return values:
+//  - explanation: the dsl, the queries predicates become, the aggregations generated and warnings
+//    about slow or truncated queries. explanation.String() is a human readable plan
+//  - err: contains err information
+func (e *ESql) Explain(sql string, pagination ...interface{}) (explanation *Explanation, err error) {
+	sql = explainRegexp.ReplaceAllString(sql, "")
+	conv, stmt, err := e.prepare(sql)
+	if err != nil {
+		return nil, locateError(sql, err)
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		err = errorf(ErrUnsupported, "", `esql: Queries other than select not supported`)
+		return nil, err
+	}
+
+	explanation = &Explanation{}
+	conv.explanation = explanation
+	explanation.DSL, explanation.SortField, err = conv.convertSelect(*sel, "", pagination...)
+	if err != nil {
+		return nil, locateError(sql, err)
+	}
+	var dslMap map[string]interface{}
+	if err = json.Unmarshal([]byte(explanation.DSL), &dslMap); err != nil {
+		return nil, err
+	}
+	if aggs, ok := dslMap["aggs"].(map[string]interface{}); ok {
+		explanation.addAggregations("", aggs, e.bucketNumber)
+	}
+	return explanation, nil
+}
+
+// String returns the plan in a human readable format
+func (x *Explanation) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("DSL:\n")
+	var prettified bytes.Buffer
+	if err := json.Indent(&prettified, []byte(x.DSL), "  ", "  "); err == nil {
+		buf.WriteString("  " + prettified.String() + "\n")
+	} else {
+		buf.WriteString("  " + x.DSL + "\n")
+	}
+	if x.Where != "" {
+		buf.WriteString("Optimized WHERE:\n  " + x.Where + "\n")
+	}
+	if len(x.Predicates) > 0 {
+		buf.WriteString("Predicates:\n")
+		for _, predicate := range x.Predicates {
+			buf.WriteString(fmt.Sprintf("  %v -> %v\n", predicate.SQL, predicate.Query))
+		}
+	}
+	if len(x.Aggregations) > 0 {
+		buf.WriteString("Aggregations:\n")
+		for _, agg := range x.Aggregations {
+			buf.WriteString(fmt.Sprintf("  %v: %v(%v)\n", agg.Tag, agg.Type, strings.Join(agg.Fields, ", ")))
+		}
+	}
+	if len(x.Warnings) > 0 {
+		buf.WriteString("Warnings:\n")
+		for _, warning := range x.Warnings {
+			buf.WriteString("  " + warning + "\n")
+		}
+	}
+	return buf.String()
+}
+
+// addPredicate records that expr becomes dsl, x is nil if the query is not being explained
+func (x *Explanation) addPredicate(expr sqlparser.Expr, not bool, dsl string) {
+	if x == nil || dsl == "" {
+		return
+	}
+	sql := sqlparser.String(expr)
+	if not {
+		sql = sqlparser.String(&sqlparser.NotExpr{Expr: expr})
+	}
+	var query map[string]interface{}
+	if err := json.Unmarshal([]byte(dsl), &query); err != nil {
+		return
+	}
+	kind := queryKind(query)
+	x.Predicates = append(x.Predicates, PlanPredicate{SQL: sql, Query: kind})
+	if strings.Contains(kind, "script") {
+		x.Warnings = append(x.Warnings, fmt.Sprintf(`script query can not use index: %v`, sql))
+	}
+	if hasLeadingWildcard(query) {
+		x.Warnings = append(x.Warnings, fmt.Sprintf(`leading wildcard scans all terms of the field: %v`, sql))
+	}
+}
+
+// addOptimizedWhere records WHERE as the optimizer rewrites it, x is nil if the query is not being explained
+func (x *Explanation) addOptimizedWhere(where sqlparser.Expr) {
+	if x == nil {
+		return
+	}
+	x.Where = sqlparser.String(where)
+}
+
+// addWarning records a warning, x is nil if the query is not being explained
+func (x *Explanation) addWarning(format string, args ...interface{}) {
+	if x == nil {
+		return
+	}
+	x.Warnings = append(x.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (x *Explanation) addAggregations(parent string, aggs map[string]interface{}, bucketNumber int) {
+	var tags []string
+	for tag := range aggs {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		body, ok := aggs[tag].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path := tag
+		if parent != "" {
+			path = parent + ">" + tag
+		}
+		for aggType, aggBody := range body {
+			if aggType == "aggs" || aggType == "meta" {
+				continue
+			}
+			params, _ := aggBody.(map[string]interface{})
+			x.Aggregations = append(x.Aggregations, PlanAggregation{Tag: path, Type: aggType, Fields: aggFields(aggType, params)})
+			switch aggType {
+			case "composite":
+				x.Warnings = append(x.Warnings, fmt.Sprintf(`composite size truncated at bucketNumber: %v returns at most %v buckets per request, page by after_key or raise it by SetBucketNum`, path, bucketNumber))
+			case "bucket_sort":
+				x.Warnings = append(x.Warnings, fmt.Sprintf(`%v only sorts the %v buckets of the current page`, path, bucketNumber))
+			}
+		}
+		if subAggs, ok := body["aggs"].(map[string]interface{}); ok {
+			x.addAggregations(path, subAggs, bucketNumber)
+		}
+	}
+}
+
+// aggFields returns the fields, scripts or buckets paths an aggregation works on
+func aggFields(aggType string, params map[string]interface{}) (fields []string) {
+	switch aggType {
+	case "composite":
+		sources, _ := params["sources"].([]interface{})
+		for _, source := range sources {
+			source, _ := source.(map[string]interface{})
+			for name, value := range source {
+				valueSource, _ := value.(map[string]interface{})
+				for _, terms := range valueSource {
+					terms, _ := terms.(map[string]interface{})
+					if field, ok := terms["field"]; ok {
+						fields = append(fields, fmt.Sprint(field))
+					} else {
+						fields = append(fields, name)
+					}
+				}
+			}
+		}
+	case "bucket_script", "bucket_selector":
+		bucketsPath, _ := params["buckets_path"].(map[string]interface{})
+		for _, path := range bucketsPath {
+			fields = append(fields, fmt.Sprint(path))
+		}
+		sort.Strings(fields)
+	case "filter":
+		fields = append(fields, queryKind(params))
+	case "nested", "reverse_nested":
+		if path, ok := params["path"]; ok {
+			fields = append(fields, fmt.Sprint(path))
+		}
+	default:
+		if field, ok := params["field"]; ok {
+			fields = append(fields, fmt.Sprint(field))
+		} else if _, ok := params["script"]; ok {
+			fields = append(fields, "script")
+		}
+	}
+	return fields
+}
+
+// queryKind names the es query of a predicate, a bool query is named by the queries it combines
+func queryKind(query map[string]interface{}) string {
+	for kind, body := range query {
+		if kind != "bool" {
+			return kind
+		}
+		boolQuery, _ := body.(map[string]interface{})
+		var kinds []string
+		for _, clause := range []string{"must", "filter", "should", "must_not"} {
+			var subKinds []string
+			for _, sub := range boolClauses(boolQuery[clause]) {
+				subKinds = append(subKinds, queryKind(sub))
+			}
+			switch {
+			case len(subKinds) == 0:
+			case clause == "must_not":
+				kinds = append(kinds, "NOT "+strings.Join(subKinds, " AND NOT "))
+			case clause == "should":
+				kinds = append(kinds, strings.Join(subKinds, " OR "))
+			default:
+				kinds = append(kinds, strings.Join(subKinds, " AND "))
+			}
+		}
+		if len(kinds) == 1 {
+			return kinds[0]
+		}
+		return "bool(" + strings.Join(kinds, ", ") + ")"
+	}
+	return ""
+}
+
+// a clause of a bool query is a query or a list of queries
+func boolClauses(clause interface{}) (queries []map[string]interface{}) {
+	switch clause := clause.(type) {
+	case map[string]interface{}:
+		queries = append(queries, clause)
+	case []interface{}:
+		for _, sub := range clause {
+			if sub, ok := sub.(map[string]interface{}); ok {
+				queries = append(queries, sub)
+			}
+		}
+	}
+	return queries
+}
+
+// hasLeadingWildcard checks wildcard queries starting with * or ?, and regexp queries starting with .*
+func hasLeadingWildcard(query map[string]interface{}) bool {
+	for kind, body := range query {
+		switch kind {
+		case "wildcard", "regexp":
+			fields, _ := body.(map[string]interface{})
+			for _, value := range fields {
+				if params, ok := value.(map[string]interface{}); ok {
+					value = params[kind]
+					if value == nil {
+						value = params["value"]
+					}
+				}
+				pattern, _ := value.(string)
+				if kind == "wildcard" && (strings.HasPrefix(pattern, "*") || strings.HasPrefix(pattern, "?")) {
+					return true
+				}
+				if kind == "regexp" && (strings.HasPrefix(pattern, ".*") || strings.HasPrefix(pattern, ".+")) {
+					return true
+				}
+			}
+		case "bool":
+			boolQuery, _ := body.(map[string]interface{})
+			for _, clause := range boolQuery {
+				for _, sub := range boolClauses(clause) {
+					if hasLeadingWildcard(sub) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
@@ -2,6 +2,8 @@ package esql
 
 import (
 	"fmt"
+	"hash/fnv"
+	"strconv"
 	"strings"
 
 	"github.com/xwb1989/sqlparser"
@@ -15,6 +17,10 @@ func (e *ESql) convertCount(funcExpr sqlparser.FuncExpr) (tag string, body strin
 	if err != nil {
 		return "", "", err
 	}
+	// ES SQL counts documents w/ null values in COUNT(colName) as well
+	if e.countNulls && !funcExpr.Distinct {
+		argument = "*"
+	}
 	if argument == "*" {
 		tag = "_count"
 	} else if funcExpr.Distinct {
@@ -28,7 +34,11 @@ func (e *ESql) convertCount(funcExpr sqlparser.FuncExpr) (tag string, body strin
 	if argument == "*" {
 		body = fmt.Sprintf(`"%v": "%v"`, tag, tag)
 	} else {
-		body = fmt.Sprintf(`"%v": {"field": "%v"}`, funcName, argument)
+		field, err := e.aggField(strings.TrimPrefix(funcName, "value_"), argument)
+		if err != nil {
+			return "", "", err
+		}
+		body = fmt.Sprintf(`"%v": {"field": "%v"}`, funcName, field)
 	}
 	return tag, body, nil
 }
@@ -42,31 +52,35 @@ func (e *ESql) convertStandardArithmetic(funcExpr sqlparser.FuncExpr) (tag strin
 		return "", "", err
 	}
 	if funcExpr.Distinct {
-		err := fmt.Errorf(`esql: aggregation function %v w/ DISTINCT not supported`, funcName)
+		err := errorf(ErrUnsupported, sqlparser.String(&funcExpr), `esql: aggregation function %v w/ DISTINCT not supported`, funcName)
 		return "", "", err
 	}
 	tag = funcName + "_" + argument
 	tag = strings.Replace(tag, ".", "_", -1)
-	body = fmt.Sprintf(`"%v": {"field": "%v"}`, funcName, argument)
+	field, err := e.aggField(funcName, argument)
+	if err != nil {
+		return "", "", err
+	}
+	body = fmt.Sprintf(`"%v": {"field": "%v"}`, funcName, field)
 	return tag, body, nil
 }
 
 func (e *ESql) convertHistogram(funcExpr sqlparser.FuncExpr) (tag string, body string, err error) {
 	funcName := strings.ToLower(funcExpr.Name.String())
 	if funcName != "histogram" {
-		err = fmt.Errorf("fail to convert histogram")
+		err = errorf(ErrSyntax, sqlparser.String(&funcExpr), "fail to convert histogram")
 		return "", "", err
 	}
 
 	arguments := make(map[string]string)
 	for i, expr := range funcExpr.Exprs {
 		if i > 3 {
-			err = fmt.Errorf("fail to convert histogram")
+			err = errorf(ErrSyntax, sqlparser.String(&funcExpr), "fail to convert histogram")
 			return "", "", err
 		} 
 		aliasedExpr, ok := expr.(*sqlparser.AliasedExpr)
 		if !ok {
-			err = fmt.Errorf("fail to convert date_histogram")
+			err = errorf(ErrSyntax, sqlparser.String(&funcExpr), "fail to convert date_histogram")
 			return "", "", err
 		}
 		if i < 3 {
@@ -93,24 +107,28 @@ func (e *ESql) convertHistogram(funcExpr sqlparser.FuncExpr) (tag string, body s
 func (e *ESql) convertDateHistogram(funcExpr sqlparser.FuncExpr) (tag string, body string, err error) {
 	funcName := strings.ToLower(funcExpr.Name.String())
 	if funcName != "date_histogram" {
-		err = fmt.Errorf("fail to convert date_histogram")
+		err = errorf(ErrSyntax, sqlparser.String(&funcExpr), "fail to convert date_histogram")
 		return "", "", err
 	}
 
 	arguments := make(map[string]string)
 	for i, expr := range funcExpr.Exprs {
-		if i > 2 {
-			err = fmt.Errorf("fail to convert date_histogram")
+		if i >= len(dateHistogramTags) {
+			err = errorf(ErrSyntax, sqlparser.String(&funcExpr), "fail to convert date_histogram")
 			return "", "", err
 		}
 		aliasedExpr, ok := expr.(*sqlparser.AliasedExpr)
 		if !ok {
-			err = fmt.Errorf("fail to convert date_histogram")
+			err = errorf(ErrSyntax, sqlparser.String(&funcExpr), "fail to convert date_histogram")
 			return "", "", err
 		}
 		arguments[dateHistogramTags[i]] = strings.Trim(sqlparser.String(aliasedExpr.Expr), "'")
 	}
 
+	if _, exist := arguments["time_zone"]; !exist && e.timeZone != "" {
+		arguments["time_zone"] = e.timeZone
+	}
+
 	tag = funcName + "_" + arguments["field"]
 	var aggBodys []string
 	for k, v := range arguments {
@@ -125,7 +143,7 @@ func (e *ESql) convertDateHistogram(funcExpr sqlparser.FuncExpr) (tag string, bo
 func (e *ESql) convertRange(funcExpr sqlparser.FuncExpr) (tag string, body string, err error) {
 	funcName := strings.ToLower(funcExpr.Name.String())
 	if funcName != "range" {
-		err = fmt.Errorf("fail to convert range aggregation")
+		err = errorf(ErrSyntax, sqlparser.String(&funcExpr), "fail to convert range aggregation")
 		return "", "", err
 	}
 
@@ -134,7 +152,7 @@ func (e *ESql) convertRange(funcExpr sqlparser.FuncExpr) (tag string, body strin
 	for i, expr := range funcExpr.Exprs {
 		aliasedExpr, ok := expr.(*sqlparser.AliasedExpr)
 		if !ok {
-			err = fmt.Errorf("fail to convert date_histogram")
+			err = errorf(ErrSyntax, sqlparser.String(&funcExpr), "fail to convert date_histogram")
 			return "", "", err
 		}
 		if i == 0 {
@@ -170,7 +188,7 @@ func (e *ESql) convertRange(funcExpr sqlparser.FuncExpr) (tag string, body strin
 func (e *ESql) convertDateRange(funcExpr sqlparser.FuncExpr) (tag string, body string, err error) {
 	funcName := strings.ToLower(funcExpr.Name.String())
 	if funcName != "date_range" {
-		err = fmt.Errorf("fail to convert date_range aggregation")
+		err = errorf(ErrSyntax, sqlparser.String(&funcExpr), "fail to convert date_range aggregation")
 		return "", "", err
 	}
 
@@ -179,7 +197,7 @@ func (e *ESql) convertDateRange(funcExpr sqlparser.FuncExpr) (tag string, body s
 	for i, expr := range funcExpr.Exprs {
 		aliasedExpr, ok := expr.(*sqlparser.AliasedExpr)
 		if !ok {
-			err = fmt.Errorf("fail to convert date_range")
+			err = errorf(ErrSyntax, sqlparser.String(&funcExpr), "fail to convert date_range")
 			return "", "", err
 		}
 		if i < 2 {
@@ -201,6 +219,9 @@ func (e *ESql) convertDateRange(funcExpr sqlparser.FuncExpr) (tag string, body s
 	rangeBodies = append(rangeBodies, fmt.Sprintf(`{"to": "%v"}`, ranges[0]))
 	rangeBodies = append(rangeBodies, fmt.Sprintf(`{"from": "%v"}`, ranges[len(ranges)-1]))
 	arguments["ranges"] = fmt.Sprintf(`[%v]`, strings.Join(rangeBodies, ","))
+	if e.timeZone != "" {
+		arguments["time_zone"] = fmt.Sprintf(`"%v"`, e.timeZone)
+	}
 	var aggBodys []string
 	for k, v := range arguments {
 		aggBodys = append(aggBodys, fmt.Sprintf(`"%v": %v`, k, v))
@@ -210,3 +231,228 @@ func (e *ESql) convertDateRange(funcExpr sqlparser.FuncExpr) (tag string, body s
 	tag = strings.Replace(tag, ".", "_", -1)
 	return tag, body, nil
 }
+
+// convertIPRange converts IP_RANGE(col, bound1, bound2, ...) to an ip_range aggregation. bounds are either
+// addresses, which split the ip space like RANGE, or CIDR blocks, each of which is a range by its mask
+func (e *ESql) convertIPRange(funcExpr sqlparser.FuncExpr) (tag string, body string, err error) {
+	args, err := funcExprArgs(&funcExpr)
+	if err != nil {
+		return "", "", err
+	}
+	if len(args) < 2 {
+		err = errorf(ErrSyntax, sqlparser.String(&funcExpr), `esql: IP_RANGE requires a column and at least 1 bound`)
+		return "", "", err
+	}
+	colName, ok := args[0].(*sqlparser.ColName)
+	if !ok {
+		err = errorf(ErrSyntax, sqlparser.String(&funcExpr), `esql: first argument of IP_RANGE must be a column`)
+		return "", "", err
+	}
+	field, err := e.convertColName(colName)
+	if err != nil {
+		return "", "", err
+	}
+	if mapping, exist := e.fieldMapping(field); exist && mapping.Type != "ip" {
+		err = errorf(ErrPolicy, field, `esql: IP_RANGE on %v field %v not supported`, mapping.Type, field)
+		return "", "", err
+	}
+	var bounds []string
+	masks := 0
+	for _, arg := range args[1:] {
+		lit, ok := convertLiteral(arg)
+		if !ok || lit.typ != literalString || !isIPValue(lit.val) {
+			err = errorf(ErrSyntax, sqlparser.String(arg), `esql: bound of IP_RANGE must be an ip address or a CIDR block`)
+			return "", "", err
+		}
+		if strings.Contains(lit.val, "/") {
+			masks++
+		}
+		bounds = append(bounds, jsonString(lit.val))
+	}
+	var rangeBodies []string
+	switch masks {
+	case len(bounds):
+		for _, bound := range bounds {
+			rangeBodies = append(rangeBodies, fmt.Sprintf(`{"mask": %v}`, bound))
+		}
+	case 0:
+		rangeBodies = append(rangeBodies, fmt.Sprintf(`{"to": %v}`, bounds[0]))
+		for i := 0; i+1 < len(bounds); i++ {
+			rangeBodies = append(rangeBodies, fmt.Sprintf(`{"from": %v, "to": %v}`, bounds[i], bounds[i+1]))
+		}
+		rangeBodies = append(rangeBodies, fmt.Sprintf(`{"from": %v}`, bounds[len(bounds)-1]))
+	default:
+		err = errorf(ErrSyntax, sqlparser.String(&funcExpr), `esql: IP_RANGE mixes ip addresses and CIDR blocks`)
+		return "", "", err
+	}
+	tag = strings.Replace("ip_range_"+field, ".", "_", -1)
+	body = fmt.Sprintf(`"ip_range": {"field": "%v", "ranges": [%v]}`, field, strings.Join(rangeBodies, ","))
+	return tag, body, nil
+}
+
+// convertFilteredAggregation converts AGG(...) FILTER (WHERE cond), which is rewritten to
+// aggregate_filter(AGG(...), cond) before parsing, to a filter aggregation that wraps AGG(...)
+func (e *ESql) convertFilteredAggregation(funcExpr sqlparser.FuncExpr) (tag string, agg aggregation, err error) {
+	var exprs []sqlparser.Expr
+	for _, selectExpr := range funcExpr.Exprs {
+		aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr)
+		if !ok {
+			err = errorf(ErrSyntax, sqlparser.String(&funcExpr), `esql: invalid FILTER clause`)
+			return "", aggregation{}, err
+		}
+		exprs = append(exprs, aliasedExpr.Expr)
+	}
+	if len(exprs) != 2 {
+		err = errorf(ErrSyntax, sqlparser.String(&funcExpr), `esql: invalid FILTER clause`)
+		return "", aggregation{}, err
+	}
+	aggExpr, ok := exprs[0].(*sqlparser.FuncExpr)
+	if !ok {
+		err = errorf(ErrSyntax, sqlparser.String(exprs[0]), `esql: FILTER must follow an aggregation function`)
+		return "", aggregation{}, err
+	}
+	if _, isCase := caseAggregationArgument(*aggExpr); isCase || strings.ToLower(aggExpr.Name.String()) == "aggregate_filter" {
+		err = errorf(ErrUnsupported, sqlparser.String(&funcExpr), `esql: FILTER on a filtered aggregation not supported`)
+		return "", aggregation{}, err
+	}
+	aggTag, aggBody, err := e.convertFuncExpr(*aggExpr)
+	if err != nil {
+		return "", aggregation{}, err
+	}
+	filterDsl, err := e.convertWhereExpr(exprs[1], nil)
+	if err != nil {
+		return "", aggregation{}, err
+	}
+	tag = strings.TrimPrefix(aggTag, "_") + "_filter_" + exprHash(exprs[1])
+	return tag, filteredAggBody(filterDsl, aggTag, aggBody), nil
+}
+
+// caseAggregationArgument checks whether funcExpr is an aggregation over a CASE expression,
+// e.g. SUM(CASE WHEN colA > 0 THEN colA ELSE 0 END)
+func caseAggregationArgument(funcExpr sqlparser.FuncExpr) (*sqlparser.CaseExpr, bool) {
+	switch strings.ToLower(funcExpr.Name.String()) {
+	case "count", "avg", "sum", "min", "max":
+	default:
+		return nil, false
+	}
+	if len(funcExpr.Exprs) != 1 {
+		return nil, false
+	}
+	aliasedExpr, ok := funcExpr.Exprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return nil, false
+	}
+	caseExpr, ok := aliasedExpr.Expr.(*sqlparser.CaseExpr)
+	return caseExpr, ok
+}
+
+// convertCaseAggregation converts AGG(CASE WHEN cond THEN val END) to a filter aggregation on cond
+// that wraps AGG(val), rows going to ELSE must not change the aggregation result
+func (e *ESql) convertCaseAggregation(funcExpr sqlparser.FuncExpr, caseExpr *sqlparser.CaseExpr) (tag string, agg aggregation, err error) {
+	funcName := strings.ToLower(funcExpr.Name.String())
+	if len(caseExpr.Whens) != 1 {
+		err = errorf(ErrUnsupported, sqlparser.String(caseExpr), `esql: CASE in aggregation function %v only support a single WHEN`, funcName)
+		return "", aggregation{}, err
+	}
+	if !isNullExpr(caseExpr.Else) && !(funcName == "sum" && isZeroExpr(caseExpr.Else)) {
+		err = errorf(ErrUnsupported, sqlparser.String(caseExpr), `esql: ELSE of CASE in aggregation function %v not supported`, funcName)
+		return "", aggregation{}, err
+	}
+	when := caseExpr.Whens[0]
+	if isNullExpr(when.Val) {
+		err = errorf(ErrSyntax, sqlparser.String(caseExpr), `esql: THEN of CASE in aggregation function %v should not be NULL`, funcName)
+		return "", aggregation{}, err
+	}
+	cond := when.Cond
+	if caseExpr.Expr != nil {
+		cond = &sqlparser.ComparisonExpr{Operator: sqlparser.EqualStr, Left: caseExpr.Expr, Right: when.Cond}
+	}
+	filterDsl, err := e.convertWhereExpr(cond, nil)
+	if err != nil {
+		return "", aggregation{}, err
+	}
+
+	var aggTag string
+	var aggBody aggregation
+	_, isColName := when.Val.(*sqlparser.ColName)
+	_, isSQLVal := when.Val.(*sqlparser.SQLVal)
+	_, isBoolVal := when.Val.(sqlparser.BoolVal)
+	switch {
+	case isColName:
+		aggExpr := sqlparser.FuncExpr{
+			Name:     funcExpr.Name,
+			Distinct: funcExpr.Distinct,
+			Exprs:    sqlparser.SelectExprs{&sqlparser.AliasedExpr{Expr: when.Val}},
+		}
+		aggTag, aggBody, err = e.convertFuncExpr(aggExpr)
+		if err != nil {
+			return "", aggregation{}, err
+		}
+	case funcName == "count" && (isSQLVal || isBoolVal):
+		// a non null constant is counted once for every row
+		if funcExpr.Distinct {
+			err = errorf(ErrUnsupported, sqlparser.String(&funcExpr), `esql: COUNT DISTINCT on a constant not supported`)
+			return "", aggregation{}, err
+		}
+		aggTag = "_count"
+	default:
+		aggMapsDummy := make(map[string]aggregation)
+		script, params, err := e.convertToDocScript(func() (string, error) {
+			return e.convertToScript(when.Val, aggMapsDummy)
+		})
+		if err != nil {
+			return "", aggregation{}, err
+		}
+		if len(aggMapsDummy) > 0 {
+			err = errorf(ErrSyntax, sqlparser.String(&funcExpr), `esql: aggregation inside aggregation function %v`, funcName)
+			return "", aggregation{}, err
+		}
+		aggFuncName := funcName
+		if funcName == "count" {
+			aggFuncName = "value_count"
+			if funcExpr.Distinct {
+				aggFuncName = "cardinality"
+			}
+		} else if funcExpr.Distinct {
+			err = errorf(ErrUnsupported, sqlparser.String(&funcExpr), `esql: aggregation function %v w/ DISTINCT not supported`, funcName)
+			return "", aggregation{}, err
+		}
+		aggTag = aggFuncName
+		aggBody.body = fmt.Sprintf(`"%v": {"script": {"source": "%v"%v}}`, aggFuncName, script, params)
+	}
+	tag = funcName + "_case_" + exprHash(&funcExpr)
+	return tag, filteredAggBody(filterDsl, aggTag, aggBody), nil
+}
+
+// filteredAggBody wraps aggBody by a filter aggregation, whose doc count is the value of COUNT(*)
+func filteredAggBody(filterDsl string, aggTag string, aggBody aggregation) aggregation {
+	if aggTag == "_count" {
+		return aggregation{body: fmt.Sprintf(`"filter": %v`, filterDsl), path: ">_count"}
+	}
+	body := fmt.Sprintf(`"filter": %v, "aggs": {"value": {%v}}`, filterDsl, aggBody.body)
+	return aggregation{body: body, path: ">value" + aggBody.path}
+}
+
+func isNullExpr(expr sqlparser.Expr) bool {
+	if expr == nil {
+		return true
+	}
+	_, ok := expr.(*sqlparser.NullVal)
+	return ok
+}
+
+func isZeroExpr(expr sqlparser.Expr) bool {
+	val, ok := expr.(*sqlparser.SQLVal)
+	if !ok || (val.Type != sqlparser.IntVal && val.Type != sqlparser.FloatVal) {
+		return false
+	}
+	num, err := strconv.ParseFloat(string(val.Val), 64)
+	return err == nil && num == 0
+}
+
+// exprHash gives a short and stable tag suffix for an expression
+func exprHash(expr sqlparser.SQLNode) string {
+	h := fnv.New32a()
+	h.Write([]byte(sqlparser.String(expr)))
+	return fmt.Sprintf(`%08x`, h.Sum32())
+}
@@ -0,0 +1,248 @@
+package esql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// isGeoFunc checks GEO_BOUNDING_BOX(col, top, left, bottom, right) and GEO_POLYGON(col, lat1, lon1, ...),
+// predicates on the location of a geo_point column
+func isGeoFunc(expr sqlparser.Expr) bool {
+	funcExpr, ok := expr.(*sqlparser.FuncExpr)
+	if !ok {
+		return false
+	}
+	funcName := strings.ToLower(funcExpr.Name.String())
+	return funcName == "geo_bounding_box" || funcName == "geo_polygon"
+}
+
+// isGeoDistanceFunc checks GEO_DISTANCE(col, lat, lon), the distance from a geo_point column to a point
+func isGeoDistanceFunc(expr sqlparser.Expr) bool {
+	funcExpr, ok := expr.(*sqlparser.FuncExpr)
+	return ok && funcExpr.Name.EqualString("geo_distance")
+}
+
+// geoFuncArgs returns the geo_point field and the coordinates of a geo function, coordinates must be
+// numbers. with a schema the column must be a geo_point
+func (e *ESql) geoFuncArgs(funcExpr *sqlparser.FuncExpr) (field string, coords []string, err error) {
+	funcName := strings.ToUpper(funcExpr.Name.String())
+	args, err := funcExprArgs(funcExpr)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(args) == 0 {
+		err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: %v requires a column`, funcName)
+		return "", nil, err
+	}
+	colName, ok := args[0].(*sqlparser.ColName)
+	if !ok {
+		err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: first argument of %v must be a column`, funcName)
+		return "", nil, err
+	}
+	field, err = e.convertColName(colName)
+	if err != nil {
+		return "", nil, err
+	}
+	if mapping, exist := e.fieldMapping(field); exist && mapping.Type != "geo_point" {
+		err = errorf(ErrPolicy, field, `esql: %v on %v field %v not supported`, funcName, mapping.Type, field)
+		return "", nil, err
+	}
+	for _, arg := range args[1:] {
+		lit, ok := convertLiteral(arg)
+		if !ok || (lit.typ != literalInt && lit.typ != literalFloat) {
+			err = errorf(ErrSyntax, sqlparser.String(arg), `esql: coordinates of %v must be numbers`, funcName)
+			return "", nil, err
+		}
+		coords = append(coords, lit.val)
+	}
+	return field, coords, nil
+}
+
+// geoPoint returns a point in json from its latitude and longitude
+func geoPoint(lat string, lon string) string {
+	return fmt.Sprintf(`{"lat": %v, "lon": %v}`, lat, lon)
+}
+
+// convertGeoExpr converts GEO_BOUNDING_BOX to a geo_bounding_box query and GEO_POLYGON to a geo_polygon query
+func (e *ESql) convertGeoExpr(expr sqlparser.Expr) (string, error) {
+	funcExpr := expr.(*sqlparser.FuncExpr)
+	field, coords, err := e.geoFuncArgs(funcExpr)
+	if err != nil {
+		return "", err
+	}
+	if funcExpr.Name.EqualString("geo_bounding_box") {
+		if len(coords) != 4 {
+			err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: GEO_BOUNDING_BOX requires a column, top, left, bottom and right`)
+			return "", err
+		}
+		topLeft, bottomRight := geoPoint(coords[0], coords[1]), geoPoint(coords[2], coords[3])
+		dsl := fmt.Sprintf(`{"geo_bounding_box": {"%v": {"top_left": %v, "bottom_right": %v}}}`, field, topLeft, bottomRight)
+		return dsl, nil
+	}
+	if len(coords) < 6 || len(coords)%2 != 0 {
+		err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: GEO_POLYGON requires a column and the latitude and longitude of at least 3 points`)
+		return "", err
+	}
+	var points []string
+	for i := 0; i < len(coords); i += 2 {
+		points = append(points, geoPoint(coords[i], coords[i+1]))
+	}
+	dsl := fmt.Sprintf(`{"geo_polygon": {"%v": {"points": [%v]}}}`, field, strings.Join(points, ","))
+	return dsl, nil
+}
+
+// geoDistanceArgs returns the field and the latitude and longitude of the origin of GEO_DISTANCE(col, lat, lon)
+func (e *ESql) geoDistanceArgs(funcExpr *sqlparser.FuncExpr) (field string, lat string, lon string, err error) {
+	field, coords, err := e.geoFuncArgs(funcExpr)
+	if err != nil {
+		return "", "", "", err
+	}
+	if len(coords) != 2 {
+		err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: GEO_DISTANCE requires a column, a latitude and a longitude`)
+		return "", "", "", err
+	}
+	return field, coords[0], coords[1], nil
+}
+
+// the meters of each distance unit of es
+var geoDistanceUnits = map[string]float64{
+	"":              1,
+	"m":             1,
+	"meters":        1,
+	"km":            1000,
+	"kilometers":    1000,
+	"cm":            0.01,
+	"centimeters":   0.01,
+	"mm":            0.001,
+	"millimeters":   0.001,
+	"mi":            1609.344,
+	"miles":         1609.344,
+	"yd":            0.9144,
+	"yards":         0.9144,
+	"ft":            0.3048,
+	"feet":          0.3048,
+	"in":            0.0254,
+	"inch":          0.0254,
+	"nm":            1852,
+	"nmi":           1852,
+	"nauticalmiles": 1852,
+}
+
+var geoDistanceRegexp = regexp.MustCompile(`^\s*([0-9]*\.?[0-9]+)\s*([a-zA-Z]*)\s*$`)
+
+// geoDistanceMeters returns a distance, a number in meters or a string w/ unit like '10km', in meters
+func geoDistanceMeters(distance literal) (float64, bool) {
+	if distance.typ != literalString {
+		meters, err := strconv.ParseFloat(distance.val, 64)
+		return meters, err == nil
+	}
+	match := geoDistanceRegexp.FindStringSubmatch(distance.val)
+	if match == nil {
+		return 0, false
+	}
+	unit, ok := geoDistanceUnits[strings.ToLower(match[2])]
+	if !ok {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	return value * unit, err == nil
+}
+
+// convertGeoDistanceExpr converts GEO_DISTANCE(col, lat, lon) <= distance to a geo_distance query, distance
+// is a number in meters or a string w/ unit like '10km'. geo_distance includes its boundary, so < is
+// filtered by the arc distance in painless as well, and > and >= are the negation of <= and <
+func (e *ESql) convertGeoDistanceExpr(comparisonExpr *sqlparser.ComparisonExpr, op string) (string, error) {
+	field, lat, lon, err := e.geoDistanceArgs(comparisonExpr.Left.(*sqlparser.FuncExpr))
+	if err != nil {
+		return "", err
+	}
+	distance, ok := convertLiteral(comparisonExpr.Right)
+	if !ok || (distance.typ != literalInt && distance.typ != literalFloat && distance.typ != literalString) {
+		err = errorf(ErrSyntax, sqlparser.String(comparisonExpr.Right), `esql: GEO_DISTANCE must be compared to a distance`)
+		return "", err
+	}
+	dsl := fmt.Sprintf(`{"geo_distance": {"distance": %v, "%v": %v}}`, distance.json(), field, geoPoint(lat, lon))
+	switch op {
+	case "<=":
+		return dsl, nil
+	case ">":
+		return e.mustNot(field, dsl), nil
+	case "<", ">=":
+	default:
+		err = errorf(ErrUnsupported, sqlparser.String(comparisonExpr), `esql: %v operator on GEO_DISTANCE not supported`, op)
+		return "", err
+	}
+
+	meters, ok := geoDistanceMeters(distance)
+	if !ok {
+		err = errorf(ErrSyntax, sqlparser.String(comparisonExpr.Right), `esql: invalid distance %v`, distance.val)
+		return "", err
+	}
+	source, params, err := e.convertToDocScript(func() (string, error) {
+		var args []string
+		for _, arg := range []literal{{literalFloat, lat}, {literalFloat, lon}, {literalFloat, strconv.FormatFloat(meters, 'f', -1, 64)}} {
+			if e.docScript != nil {
+				args = append(args, e.docScript.param(arg))
+			} else {
+				args = append(args, arg.val)
+			}
+		}
+		return fmt.Sprintf(`doc['%v'].size() != 0 && doc['%v'].arcDistance(%v, %v) < %v`, field, field, args[0], args[1], args[2]), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	dsl = fmt.Sprintf(`{"bool": {"filter": [%v,{"script": {"script": {"source": "%v"%v}}}]}}`, dsl, source, params)
+	if op == ">=" {
+		return e.mustNot(field, dsl), nil
+	}
+	return dsl, nil
+}
+
+// convertGeoDistanceSort converts ORDER BY GEO_DISTANCE(col, lat, lon) to a _geo_distance sort
+func (e *ESql) convertGeoDistanceSort(orderExpr *sqlparser.Order) (string, error) {
+	field, lat, lon, err := e.geoDistanceArgs(orderExpr.Expr.(*sqlparser.FuncExpr))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`{"_geo_distance": {"%v": %v, "order": "%v"}}`, field, geoPoint(lat, lon), orderExpr.Direction), nil
+}
+
+// convertGeoAggregation converts GEO_BOUNDS(col) and GEO_CENTROID(col) to metric aggregations, and
+// GEOHASH_GRID(col, precision) to a geohash_grid aggregation
+func (e *ESql) convertGeoAggregation(funcExpr sqlparser.FuncExpr) (tag string, body string, err error) {
+	funcName := strings.ToLower(funcExpr.Name.String())
+	field, coords, err := e.geoFuncArgs(&funcExpr)
+	if err != nil {
+		return "", "", err
+	}
+	tag = strings.Replace(funcName+"_"+field, ".", "_", -1)
+	if funcName != "geohash_grid" {
+		if len(coords) != 0 {
+			err = errorf(ErrSyntax, sqlparser.String(&funcExpr), `esql: %v requires exactly 1 column`, strings.ToUpper(funcName))
+			return "", "", err
+		}
+		return tag, fmt.Sprintf(`"%v": {"field": "%v"}`, funcName, field), nil
+	}
+	if len(coords) != 1 {
+		err = errorf(ErrSyntax, sqlparser.String(&funcExpr), `esql: GEOHASH_GRID requires a column and a precision`)
+		return "", "", err
+	}
+	body = fmt.Sprintf(`"geohash_grid": {"field": "%v", "precision": %v, "size": %v}`, field, coords[0], e.bucketNumber)
+	return tag, body, nil
+}
+
+// geohashGridGroupBy returns GROUP BY GEOHASH_GRID(col, precision), which groups by the geohash cells of
+// col rather than by columns. composite aggregations have no geohash source, so it must be the only item
+func geohashGridGroupBy(groupBy sqlparser.GroupBy) (*sqlparser.FuncExpr, bool) {
+	for _, expr := range groupBy {
+		if funcExpr, ok := expr.(*sqlparser.FuncExpr); ok && funcExpr.Name.EqualString("geohash_grid") {
+			return funcExpr, true
+		}
+	}
+	return nil, false
+}
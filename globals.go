@@ -23,6 +23,10 @@ var oppositeOperator = map[string]string{
 	"not regexp":            "regexp",
 	sqlparser.IsNullStr:     sqlparser.IsNotNullStr,
 	sqlparser.IsNotNullStr:  sqlparser.IsNullStr,
+	sqlparser.IsTrueStr:     sqlparser.IsNotTrueStr,
+	sqlparser.IsNotTrueStr:  sqlparser.IsTrueStr,
+	sqlparser.IsFalseStr:    sqlparser.IsNotFalseStr,
+	sqlparser.IsNotFalseStr: sqlparser.IsFalseStr,
 	sqlparser.BetweenStr:    sqlparser.NotBetweenStr,
 	sqlparser.NotBetweenStr: sqlparser.BetweenStr,
 }
@@ -57,7 +61,7 @@ var opUnaryExpr = map[string]string{
 	"+": "+",
 }
 
-var dateHistogramTags = []string{"field", "interval", "format"}
+var dateHistogramTags = []string{"field", "interval", "format", "time_zone"}
 var histogramTags = []string{"field", "interval", "min_doc_count", "extended_bounds"}
 var rangeTags = []string{"field", "ranges"}
 var dateRangeTags = []string{"field", "format", "ranges"}
@@ -2,28 +2,22 @@ package esql
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/xwb1989/sqlparser"
 )
 
-func (e *ESql) convertHaving(having *sqlparser.Where, aggMaps map[string]string) (dsl string, err error) {
+func (e *ESql) convertHaving(having *sqlparser.Where, aggMaps map[string]aggregation) (dsl string, err error) {
 	if having != nil {
 		script, err := e.convertHavingExpr(having.Expr, aggMaps)
 		if err != nil {
 			return "", err
 		}
-		var bucketPathSlice []string
-		for tag := range aggMaps {
-			bucketPathSlice = append(bucketPathSlice, fmt.Sprintf(`"%v": "%v"`, tag, tag))
-		}
-		bucketPathStr := strings.Join(bucketPathSlice, ",")
-		dsl = fmt.Sprintf(`"bucket_selector": {"buckets_path": {%v}, "script": "%v"}`, bucketPathStr, script)
+		dsl = fmt.Sprintf(`"bucket_selector": {"buckets_path": {%v}, "script": "%v"}`, bucketPaths(aggMaps), script)
 	}
 	return dsl, err
 }
 
-func (e *ESql) convertHavingExpr(expr sqlparser.Expr, aggMaps map[string]string) (string, error) {
+func (e *ESql) convertHavingExpr(expr sqlparser.Expr, aggMaps map[string]aggregation) (string, error) {
 	switch expr.(type) {
 	case *sqlparser.ComparisonExpr:
 		return e.convertHavingComparisionExpr(expr, aggMaps)
@@ -37,22 +31,31 @@ func (e *ESql) convertHavingExpr(expr sqlparser.Expr, aggMaps map[string]string)
 		return e.convertHavingParenExpr(expr, aggMaps)
 	case *sqlparser.RangeCond:
 		return e.convertHavingBetweenExpr(expr, aggMaps)
+	case *sqlparser.IsExpr:
+		return e.convertHavingIsExpr(expr, aggMaps)
 	// TODO: case *sqlparser.BinaryExpr
 	default:
-		err := fmt.Errorf(`esql: %T expression in HAVING no supported`, expr)
+		err := errorf(ErrUnsupported, sqlparser.String(expr), `esql: %T expression in HAVING no supported`, expr)
 		return "", err
 	}
 }
 
-func (e *ESql) convertHavingBetweenExpr(expr sqlparser.Expr, aggMaps map[string]string) (string, error) {
+func (e *ESql) convertHavingBetweenExpr(expr sqlparser.Expr, aggMaps map[string]aggregation) (string, error) {
 	rangeCond := expr.(*sqlparser.RangeCond)
 	lhs := rangeCond.Left
 	from, to := rangeCond.From, rangeCond.To
-	var expr1 sqlparser.Expr = &sqlparser.ComparisonExpr{Left: lhs, Right: from, Operator: ">="}
-	var expr2 sqlparser.Expr = &sqlparser.ComparisonExpr{Left: lhs, Right: to, Operator: "<="}
-	var expr3 sqlparser.Expr = &sqlparser.AndExpr{Left: expr1, Right: expr2}
-
-	script, err := e.convertHavingAndExpr(expr3, aggMaps)
+	var script string
+	var err error
+	if rangeCond.Operator == sqlparser.NotBetweenStr {
+		// each side is checked not null, so NOT BETWEEN is false rather than true if lhs is null
+		var expr1 sqlparser.Expr = &sqlparser.ComparisonExpr{Left: lhs, Right: from, Operator: "<"}
+		var expr2 sqlparser.Expr = &sqlparser.ComparisonExpr{Left: lhs, Right: to, Operator: ">"}
+		script, err = e.convertHavingOrExpr(&sqlparser.OrExpr{Left: expr1, Right: expr2}, aggMaps)
+	} else {
+		var expr1 sqlparser.Expr = &sqlparser.ComparisonExpr{Left: lhs, Right: from, Operator: ">="}
+		var expr2 sqlparser.Expr = &sqlparser.ComparisonExpr{Left: lhs, Right: to, Operator: "<="}
+		script, err = e.convertHavingAndExpr(&sqlparser.AndExpr{Left: expr1, Right: expr2}, aggMaps)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -62,7 +65,27 @@ func (e *ESql) convertHavingBetweenExpr(expr sqlparser.Expr, aggMaps map[string]
 	return script, nil
 }
 
-func (e *ESql) convertHavingAndExpr(expr sqlparser.Expr, aggMaps map[string]string) (string, error) {
+func (e *ESql) convertHavingIsExpr(expr sqlparser.Expr, aggMaps map[string]aggregation) (string, error) {
+	isExpr := expr.(*sqlparser.IsExpr)
+	_, notNull, err := e.convertToNullCheckedScript(isExpr.Expr, aggMaps)
+	if err != nil {
+		return "", err
+	}
+	if notNull == "" {
+		notNull = "true"
+	}
+	switch isExpr.Operator {
+	case sqlparser.IsNullStr:
+		return fmt.Sprintf(`!(%v)`, notNull), nil
+	case sqlparser.IsNotNullStr:
+		return notNull, nil
+	default:
+		err := errorf(ErrUnsupported, sqlparser.String(isExpr), "esql: is expression only support is null and is not null")
+		return "", err
+	}
+}
+
+func (e *ESql) convertHavingAndExpr(expr sqlparser.Expr, aggMaps map[string]aggregation) (string, error) {
 
 	andExpr := expr.(*sqlparser.AndExpr)
 	leftExpr := andExpr.Left
@@ -78,7 +101,7 @@ func (e *ESql) convertHavingAndExpr(expr sqlparser.Expr, aggMaps map[string]stri
 	return fmt.Sprintf(`%v && %v`, scriptLeft, scriptRight), nil
 }
 
-func (e *ESql) convertHavingOrExpr(expr sqlparser.Expr, aggMaps map[string]string) (string, error) {
+func (e *ESql) convertHavingOrExpr(expr sqlparser.Expr, aggMaps map[string]aggregation) (string, error) {
 
 	orExpr := expr.(*sqlparser.OrExpr)
 	leftExpr := orExpr.Left
@@ -94,7 +117,7 @@ func (e *ESql) convertHavingOrExpr(expr sqlparser.Expr, aggMaps map[string]strin
 	return fmt.Sprintf(`%v || %v`, scriptLeft, scriptRight), nil
 }
 
-func (e *ESql) convertHavingParenExpr(expr sqlparser.Expr, aggMaps map[string]string) (string, error) {
+func (e *ESql) convertHavingParenExpr(expr sqlparser.Expr, aggMaps map[string]aggregation) (string, error) {
 
 	parenExpr := expr.(*sqlparser.ParenExpr)
 	script, err := e.convertHavingExpr(parenExpr.Expr, aggMaps)
@@ -104,9 +127,30 @@ func (e *ESql) convertHavingParenExpr(expr sqlparser.Expr, aggMaps map[string]st
 	return fmt.Sprintf(`(%v)`, script), nil
 }
 
-func (e *ESql) convertHavingNotExpr(expr sqlparser.Expr, aggMaps map[string]string) (string, error) {
+func (e *ESql) convertHavingNotExpr(expr sqlparser.Expr, aggMaps map[string]aggregation) (string, error) {
 
 	notExpr := expr.(*sqlparser.NotExpr)
+	// NOT of a comparison or BETWEEN with null is still unknown, so negate the operator instead
+	inner := notExpr.Expr
+	for {
+		parenExpr, ok := inner.(*sqlparser.ParenExpr)
+		if !ok {
+			break
+		}
+		inner = parenExpr.Expr
+	}
+	if comparisonExpr, ok := inner.(*sqlparser.ComparisonExpr); ok && e.docScript != nil {
+		if op, ok := oppositeOperator[comparisonExpr.Operator]; ok {
+			negated := *comparisonExpr
+			negated.Operator = op
+			return e.convertHavingComparisionExpr(&negated, aggMaps)
+		}
+	}
+	if rangeCond, ok := inner.(*sqlparser.RangeCond); ok && e.docScript != nil {
+		negated := *rangeCond
+		negated.Operator = oppositeOperator[rangeCond.Operator]
+		return e.convertHavingBetweenExpr(&negated, aggMaps)
+	}
 	script, err := e.convertHavingExpr(notExpr.Expr, aggMaps)
 	if err != nil {
 		return "", err
@@ -114,24 +158,21 @@ func (e *ESql) convertHavingNotExpr(expr sqlparser.Expr, aggMaps map[string]stri
 	return fmt.Sprintf(`!%v`, script), nil
 }
 
-func (e *ESql) convertHavingComparisionExpr(expr sqlparser.Expr, aggMaps map[string]string) (script string, err error) {
+func (e *ESql) convertHavingComparisionExpr(expr sqlparser.Expr, aggMaps map[string]aggregation) (script string, err error) {
 	comparisonExpr := expr.(*sqlparser.ComparisonExpr)
 	if _, exist := op2PainlessOp[comparisonExpr.Operator]; !exist {
-		err := fmt.Errorf(`esql: %s operator not supported in having comparison clause`, comparisonExpr.Operator)
+		err := errorf(ErrUnsupported, sqlparser.String(comparisonExpr), `esql: %s operator not supported in having comparison clause`, comparisonExpr.Operator)
 		return "", err
 	}
 	// convert SQL operator format to equivalent painless operator
 	op := op2PainlessOp[comparisonExpr.Operator]
 
-	lhsScript, err := e.convertToScript(comparisonExpr.Left, aggMaps)
+	script, notNull, err := e.convertComparisonToScript(comparisonExpr.Left, comparisonExpr.Right, op, aggMaps)
 	if err != nil {
 		return "", err
 	}
-	rhsScript, err := e.convertToScript(comparisonExpr.Right, aggMaps)
-	if err != nil {
-		return "", err
+	if notNull != "" {
+		script = fmt.Sprintf(`(%v && %v)`, notNull, script)
 	}
-	
-	script = fmt.Sprintf(`%v %v %v`, lhsScript, op, rhsScript)
 	return script, nil
 }
@@ -0,0 +1,84 @@
+package esql
+
+import (
+	"net"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// isCIDRMatchFunc checks CIDR_MATCH(col, cidr, ...), which matches ip addresses in any of the blocks
+func isCIDRMatchFunc(expr sqlparser.Expr) bool {
+	funcExpr, ok := expr.(*sqlparser.FuncExpr)
+	return ok && funcExpr.Name.EqualString("cidr_match")
+}
+
+// cidrMatchComparison rewrites CIDR_MATCH(col, cidr, ...) to col IN CIDR(cidr, ...)
+func cidrMatchComparison(funcExpr *sqlparser.FuncExpr) (*sqlparser.ComparisonExpr, error) {
+	args, err := funcExprArgs(funcExpr)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < 2 {
+		err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: CIDR_MATCH requires a column and at least 1 CIDR`)
+		return nil, err
+	}
+	if _, ok := args[0].(*sqlparser.ColName); !ok {
+		err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: first argument of CIDR_MATCH must be a column`)
+		return nil, err
+	}
+	cidrFunc := &sqlparser.FuncExpr{Name: sqlparser.NewColIdent("cidr"), Exprs: funcExpr.Exprs[1:]}
+	return &sqlparser.ComparisonExpr{Operator: sqlparser.InStr, Left: args[0], Right: sqlparser.ValTuple{cidrFunc}}, nil
+}
+
+// expandCIDR replaces CIDR(cidr, ...) in the IN list of lhs by its blocks, which term queries on ip fields
+// understand. blocks are kept as they are written, so IPv6 addresses are not reformatted
+func (e *ESql) expandCIDR(lhs sqlparser.Expr, tuple sqlparser.ValTuple) (expanded sqlparser.ValTuple, err error) {
+	for _, valExpr := range tuple {
+		funcExpr, ok := valExpr.(*sqlparser.FuncExpr)
+		if !ok || !funcExpr.Name.EqualString("cidr") {
+			expanded = append(expanded, valExpr)
+			continue
+		}
+		if colName, ok := lhs.(*sqlparser.ColName); ok {
+			colNameStr, err := e.convertColName(colName)
+			if err != nil {
+				return nil, err
+			}
+			if mapping, exist := e.fieldMapping(colNameStr); exist && mapping.Type != "ip" {
+				err = errorf(ErrPolicy, colNameStr, `esql: CIDR on %v field %v not supported`, mapping.Type, colNameStr)
+				return nil, err
+			}
+		}
+		args, err := funcExprArgs(funcExpr)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: CIDR requires at least 1 block`)
+			return nil, err
+		}
+		for _, arg := range args {
+			lit, ok := convertLiteral(arg)
+			if !ok || lit.typ != literalString {
+				err = errorf(ErrSyntax, sqlparser.String(arg), `esql: CIDR block must be a string`)
+				return nil, err
+			}
+			if _, _, err := net.ParseCIDR(lit.val); err != nil {
+				err = errorf(ErrSyntax, sqlparser.String(arg), `esql: invalid CIDR block %v`, lit.val)
+				return nil, err
+			}
+			expanded = append(expanded, arg)
+		}
+	}
+	return expanded, nil
+}
+
+// isIPValue checks val is an ip address or a CIDR block, in IPv4 or IPv6
+func isIPValue(val string) bool {
+	if strings.Contains(val, "/") {
+		_, _, err := net.ParseCIDR(val)
+		return err == nil
+	}
+	return net.ParseIP(val) != nil
+}
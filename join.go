@@ -0,0 +1,67 @@
+package esql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// the query of each parent/child predicate, and the parameter that names the type it looks up
+var joinFuncs = map[string]string{
+	"has_child":  "type",
+	"has_parent": "parent_type",
+}
+
+// isJoinFunc checks HAS_CHILD('type', cond) and HAS_PARENT('type', cond), predicates on the children
+// or the parent of a document in an index w/ join field
+func isJoinFunc(expr sqlparser.Expr) bool {
+	funcExpr, ok := expr.(*sqlparser.FuncExpr)
+	if !ok {
+		return false
+	}
+	_, exist := joinFuncs[strings.ToLower(funcExpr.Name.String())]
+	return exist
+}
+
+// convertJoinExpr converts HAS_CHILD('type', cond) to a has_child query and HAS_PARENT('type', cond)
+// to a has_parent query, cond is a WHERE condition on the children or the parent
+func (e *ESql) convertJoinExpr(expr sqlparser.Expr) (string, error) {
+	funcExpr := expr.(*sqlparser.FuncExpr)
+	funcName := strings.ToLower(funcExpr.Name.String())
+	var args []sqlparser.Expr
+	for _, selectExpr := range funcExpr.Exprs {
+		if aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr); ok {
+			args = append(args, aliasedExpr.Expr)
+		}
+	}
+	if len(args) != 2 || len(funcExpr.Exprs) != 2 {
+		err := errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: %v requires a type and a condition`, strings.ToUpper(funcName))
+		return "", err
+	}
+	joinType, ok := convertLiteral(args[0])
+	if !ok || joinType.typ != literalString {
+		err := errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: type of %v must be a string`, strings.ToUpper(funcName))
+		return "", err
+	}
+	query, err := e.convertWhereExpr(args[1], expr)
+	if err != nil {
+		return "", err
+	}
+	// no children or parent are returned by a predicate under NOT, and inner_hits of a query must have
+	// unique names, which are the type by default
+	var innerHits string
+	if e.innerHits && !e.negated {
+		if e.innerHitsOf == nil {
+			e.innerHitsOf = make(map[string]int)
+		}
+		if n := e.innerHitsOf[joinType.val]; n > 0 {
+			innerHits = fmt.Sprintf(`, "inner_hits": {"name": %v}`, jsonString(fmt.Sprintf(`%v_%v`, joinType.val, n)))
+		} else {
+			innerHits = `, "inner_hits": {}`
+		}
+		e.innerHitsOf[joinType.val]++
+	}
+	dsl := fmt.Sprintf(`{"%v": {"%v": %v, "query": %v%v}}`, funcName, joinFuncs[funcName], joinType.json(), query, innerHits)
+	return dsl, nil
+}
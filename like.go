@@ -0,0 +1,105 @@
+package esql
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// the escape character of LIKE if there is no ESCAPE clause, as in MySQL
+const defaultLikeEscape = '\\'
+
+// likeEscape returns the escape character of a LIKE, ok is false if ESCAPE '' disables escaping
+func likeEscape(comparisonExpr *sqlparser.ComparisonExpr) (escape rune, ok bool, err error) {
+	if comparisonExpr.Escape == nil {
+		return defaultLikeEscape, true, nil
+	}
+	lit, isLit := convertLiteral(comparisonExpr.Escape)
+	if !isLit || lit.typ != literalString || utf8.RuneCountInString(lit.val) > 1 {
+		err = errorf(ErrSyntax, sqlparser.String(comparisonExpr.Escape), `esql: ESCAPE must be a single character`)
+		return 0, false, err
+	}
+	if lit.val == "" {
+		return 0, false, nil
+	}
+	escape, _ = utf8.DecodeRuneInString(lit.val)
+	return escape, true, nil
+}
+
+// convertLikePattern turns a LIKE pattern into an es wildcard, in which * ? and \ of the pattern are
+// escaped. if the pattern is a literal text followed by %, prefix is the text and isPrefix is true.
+// if the pattern has no wildcard, prefix is the whole text and isText is true
+func convertLikePattern(pattern string, escape rune, hasEscape bool) (wildcard string, prefix string, isPrefix bool, isText bool) {
+	var wildcardBuf, prefixBuf bytes.Buffer
+	runes := []rune(pattern)
+	// literals seen after a wildcard, or any _, rule out a prefix query
+	literalAfterWildcard, hasAny, hasOne := false, false, false
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case hasEscape && r == escape && i+1 < len(runes):
+			i++
+			r = runes[i]
+		case r == '%':
+			wildcardBuf.WriteByte('*')
+			hasAny = true
+			continue
+		case r == '_':
+			wildcardBuf.WriteByte('?')
+			hasOne = true
+			continue
+		}
+		if r == '*' || r == '?' || r == '\\' {
+			wildcardBuf.WriteByte('\\')
+		}
+		wildcardBuf.WriteRune(r)
+		if hasAny || hasOne {
+			literalAfterWildcard = true
+		} else {
+			prefixBuf.WriteRune(r)
+		}
+	}
+	isPrefix = hasAny && !hasOne && !literalAfterWildcard && prefixBuf.Len() > 0
+	isText = !hasAny && !hasOne
+	return wildcardBuf.String(), prefixBuf.String(), isPrefix, isText
+}
+
+// convertLikeExpr returns the query of field LIKE pattern, a term query if the pattern has no wildcard,
+// a prefix query if it is a text followed by %, otherwise a wildcard query. ILIKE is case insensitive
+func (e *ESql) convertLikeExpr(comparisonExpr *sqlparser.ComparisonExpr, field string, pattern string, caseInsensitive bool) (dsl string, err error) {
+	escape, hasEscape, err := likeEscape(comparisonExpr)
+	if err != nil {
+		return "", err
+	}
+	wildcard, prefix, isPrefix, isText := convertLikePattern(pattern, escape, hasEscape)
+	switch {
+	case isText && caseInsensitive:
+		dsl = fmt.Sprintf(`{"term": {"%v": {"value": %v, "case_insensitive": true}}}`, field, jsonString(prefix))
+	case isText:
+		dsl = fmt.Sprintf(`{"term": {"%v": %v}}`, field, jsonString(prefix))
+	case isPrefix && caseInsensitive:
+		dsl = fmt.Sprintf(`{"prefix": {"%v": {"value": %v, "case_insensitive": true}}}`, field, jsonString(prefix))
+	case isPrefix:
+		dsl = fmt.Sprintf(`{"prefix": {"%v": %v}}`, field, jsonString(prefix))
+	case caseInsensitive:
+		dsl = fmt.Sprintf(`{"wildcard": {"%v": {"wildcard": %v, "case_insensitive": true}}}`, field, jsonString(wildcard))
+	default:
+		dsl = fmt.Sprintf(`{"wildcard": {"%v": {"wildcard": %v}}}`, field, jsonString(wildcard))
+	}
+	return dsl, nil
+}
+
+// unwrapILike returns the pattern of ILIKE, which is rewritten to LIKE ilike(pattern) before parsing
+func unwrapILike(expr sqlparser.Expr) (pattern sqlparser.Expr, ok bool) {
+	funcExpr, isFunc := expr.(*sqlparser.FuncExpr)
+	if !isFunc || !funcExpr.Name.EqualString("ilike") || len(funcExpr.Exprs) != 1 {
+		return expr, false
+	}
+	aliasedExpr, isAliased := funcExpr.Exprs[0].(*sqlparser.AliasedExpr)
+	if !isAliased {
+		return expr, false
+	}
+	return aliasedExpr.Expr, true
+}
@@ -0,0 +1,136 @@
+package esql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// literalType is the type of a sql literal. literals keep their type in dsl so that numeric
+// and boolean fields are queried with native json types
+type literalType int
+
+const (
+	literalString literalType = iota
+	literalInt
+	literalFloat
+	literalBool
+	literalNull
+)
+
+// literal is a sql literal, val is the unquoted value
+type literal struct {
+	typ literalType
+	val string
+}
+
+// convertLiteral converts expr to a literal, ok is false if expr is not a literal
+func convertLiteral(expr sqlparser.Expr) (lit literal, ok bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.SQLVal:
+		switch expr.Type {
+		case sqlparser.IntVal:
+			return literal{literalInt, string(expr.Val)}, true
+		case sqlparser.FloatVal:
+			return literal{literalFloat, string(expr.Val)}, true
+		case sqlparser.ValArg:
+			return literal{}, false
+		default:
+			return literal{literalString, string(expr.Val)}, true
+		}
+	case sqlparser.BoolVal:
+		return literal{literalBool, strconv.FormatBool(bool(expr))}, true
+	case *sqlparser.NullVal:
+		return literal{literalNull, "null"}, true
+	case *sqlparser.UnaryExpr:
+		// negative numbers
+		lit, ok = convertLiteral(expr.Expr)
+		if !ok || (lit.typ != literalInt && lit.typ != literalFloat) {
+			return literal{}, false
+		}
+		switch expr.Operator {
+		case sqlparser.UMinusStr:
+			if strings.HasPrefix(lit.val, "-") {
+				lit.val = lit.val[1:]
+			} else {
+				lit.val = "-" + lit.val
+			}
+			return lit, true
+		case sqlparser.UPlusStr:
+			return lit, true
+		}
+	}
+	return literal{}, false
+}
+
+// json returns the literal in json, strings are quoted and escaped
+func (lit literal) json() string {
+	if lit.typ == literalString {
+		return jsonString(lit.val)
+	}
+	return lit.val
+}
+
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	// encoding a string never fails
+	encoder.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// processLiteral applies the value macro of colName to lit, a number or boolean
+// becomes a string if the macro turns it into something else. addresses and CIDR blocks
+// of an ip field are kept as written, a macro may only map other values to addresses
+func (e *ESql) processLiteral(colName string, lit literal) (literal, error) {
+	if lit.typ == literalNull {
+		return lit, nil
+	}
+	if mapping, exist := e.fieldMapping(colName); exist && mapping.Type == "ip" && lit.typ == literalString && isIPValue(lit.val) {
+		return lit, nil
+	}
+	val, err := e.valueProcess(colName, lit.val)
+	if err != nil {
+		return literal{}, err
+	}
+	if val == lit.val {
+		return lit, nil
+	}
+	lit.val = val
+	switch lit.typ {
+	case literalInt, literalFloat:
+		if _, err := strconv.ParseFloat(val, 64); err != nil {
+			lit.typ = literalString
+		}
+	case literalBool:
+		if _, err := strconv.ParseBool(val); err != nil || (val != "true" && val != "false") {
+			lit.typ = literalString
+		}
+	}
+	return lit, nil
+}
+
+// searchAfterValue converts a pagination token to json. time.Time is converted
+// to epoch millis, which is how es returns the sort values of dates
+func searchAfterValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return strconv.FormatInt(v.UnixNano()/int64(time.Millisecond), 10), nil
+	case nil, bool, string, json.Number,
+		int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		b, err := json.Marshal(v)
+		if err != nil {
+			err = errorf(ErrSyntax, "", `esql: invalid pagination value %v: %v`, v, err)
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return jsonString(fmt.Sprintf(`%v`, v)), nil
+	}
+}
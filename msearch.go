@@ -0,0 +1,223 @@
+package esql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// msearchHeader returns the header line of r in an _msearch body, w/ its request level parameters
+func (r *SearchRequest) msearchHeader() string {
+	var params []string
+	if len(r.Index) > 0 {
+		params = append(params, fmt.Sprintf(`"index": %v`, jsonString(strings.Join(r.Index, ","))))
+	}
+	if len(r.Routing) > 0 {
+		params = append(params, fmt.Sprintf(`"routing": %v`, jsonString(strings.Join(r.Routing, ","))))
+	}
+	if r.IgnoreUnavailable {
+		params = append(params, `"ignore_unavailable": true`)
+	}
+	return "{" + strings.Join(params, ", ") + "}"
+}
+
+// msearchBody returns the NDJSON body of an _msearch of requests, a header line and a body line
+// for each request
+func msearchBody(requests []*SearchRequest) (string, error) {
+	var buf bytes.Buffer
+	for _, request := range requests {
+		buf.WriteString(request.msearchHeader())
+		buf.WriteByte('\n')
+		// a body must be in a single line
+		if err := json.Compact(&buf, []byte(request.Body)); err != nil {
+			return "", err
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String(), nil
+}
+
+// decodeMultiSearch splits an _msearch response into the responses of the searches, in the order of the
+// requests. a search that fails has its error instead
+func decodeMultiSearch(response []byte) (responses []json.RawMessage, errs []error, err error) {
+	var resp struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+	if err = json.Unmarshal(response, &resp); err != nil {
+		return nil, nil, err
+	}
+	errs = make([]error, len(resp.Responses))
+	for i, raw := range resp.Responses {
+		var failure struct {
+			Error json.RawMessage `json:"error"`
+		}
+		if err = json.Unmarshal(raw, &failure); err != nil {
+			return nil, nil, err
+		}
+		if len(failure.Error) > 0 {
+			errs[i] = errorf(ErrResponse, "", `esql: search fails: %s`, failure.Error)
+		}
+	}
+	return resp.Responses, errs, nil
+}
+
+// decodeRows flattens a search response into rows by DecodeGroupBy if it has GROUP BY buckets, or by
+// DecodeHits otherwise
+func decodeRows(response []byte) (rows []map[string]interface{}, err error) {
+	var resp struct {
+		Aggregations map[string]json.RawMessage `json:"aggregations"`
+	}
+	if err = json.Unmarshal(response, &resp); err != nil {
+		return nil, err
+	}
+	_, groupBy := resp.Aggregations["groupby"]
+	_, nested := resp.Aggregations["nested"]
+	if groupBy || nested {
+		return DecodeGroupBy(response)
+	}
+	return DecodeHits(response)
+}
+
+// Statement is a sql of a batch, Index overrides the indices the sql searches if set, and Pagination is
+// its search_after
+type Statement struct {
+	SQL        string
+	Index      []string
+	Pagination []interface{}
+}
+
+// Batch ...
+// Batch is statements converted to the searches of an _msearch, the i-th request and error are of the
+// i-th statement. a statement that fails to convert has no request and is not searched
+type Batch struct {
+	Requests []*SearchRequest
+	Errors   []error
+}
+
+// ConvertBatch ...
+// Transform sql statements to an elasticsearch multi search, statements are converted concurrently by
+// GOMAXPROCS workers
+//
+// usage:
+//  - batch := e.ConvertBatch([]esql.Statement{{SQL: sql1}, {SQL: sql2, Index: []string{"index2"}}})
+//
+// arguments:
+//  - statements: the sql statements and their indices and pagination
+//
+// return values:
+//  - batch: the search request or the conversion error of each statement, a statement failing or panicking
+//    does not fail others
+func (e *ESql) ConvertBatch(statements []Statement) *Batch {
+	batch := &Batch{
+		Requests: make([]*SearchRequest, len(statements)),
+		Errors:   make([]error, len(statements)),
+	}
+	// a bounded number of workers, so a large batch does not start a goroutine per statement
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(statements) {
+		workers = len(statements)
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				batch.Requests[i], batch.Errors[i] = e.convertStatement(statements[i])
+			}
+		}()
+	}
+	for i := range statements {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return batch
+}
+
+// convertStatement converts a statement of a batch, a panic, e.g. of a ProcessFunc, is returned as the
+// error of the statement rather than crashing the batch
+func (e *ESql) convertStatement(statement Statement) (request *SearchRequest, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			request, err = nil, errorf(ErrUnsupported, "", `esql: conversion of %v panics: %v`, statement.SQL, r)
+		}
+	}()
+	request, err = e.ConvertRequest(statement.SQL, statement.Pagination...)
+	if err != nil {
+		return nil, err
+	}
+	// as in UNION, the rows of a statement are its hits or its GROUP BY buckets
+	if request.ungrouped {
+		err = errorf(ErrUnsupported, "", `esql: aggregation w/o GROUP BY in a batch not supported`)
+		return nil, err
+	}
+	if len(statement.Index) > 0 {
+		request.Index, request.IgnoreUnavailable = statement.Index, false
+	}
+	return request, nil
+}
+
+// Path returns the url path of the _msearch of the batch
+func (b *Batch) Path() string {
+	return "/_msearch"
+}
+
+// Body returns the NDJSON body of the _msearch of the statements that are converted
+func (b *Batch) Body() (string, error) {
+	var requests []*SearchRequest
+	for _, request := range b.Requests {
+		if request != nil {
+			requests = append(requests, request)
+		}
+	}
+	return msearchBody(requests)
+}
+
+// Decode ...
+// Flatten the _msearch response of the batch into the rows of each statement
+//
+// usage:
+//  - rows, errs, err := batch.Decode(response)
+//
+// arguments:
+//  - response: the raw json response elasticsearch returns for the _msearch
+//
+// return values:
+//  - rows: the rows of the i-th statement are rows[i], as DecodeHits or DecodeGroupBy returns them
+//  - errs: errs[i] is the conversion or search error of the i-th statement, whose rows are nil
+//  - err: contains err information if the response is not of the batch
+func (b *Batch) Decode(response []byte) (rows [][]map[string]interface{}, errs []error, err error) {
+	responses, searchErrs, err := decodeMultiSearch(response)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows = make([][]map[string]interface{}, len(b.Requests))
+	errs = make([]error, len(b.Requests))
+	next := 0
+	for i, request := range b.Requests {
+		if request == nil {
+			errs[i] = b.Errors[i]
+			continue
+		}
+		if next >= len(responses) {
+			err = errorf(ErrResponse, "", `esql: %v responses for more searches of the batch`, len(responses))
+			return nil, nil, err
+		}
+		if searchErrs[next] != nil {
+			errs[i] = searchErrs[next]
+		} else {
+			rows[i], errs[i] = decodeRows(responses[next])
+		}
+		next++
+	}
+	if next != len(responses) {
+		err = errorf(ErrResponse, "", `esql: %v responses for %v searches of the batch`, len(responses), next)
+		return nil, nil, err
+	}
+	return rows, errs, nil
+}
@@ -0,0 +1,330 @@
+package esql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// nestedPaths returns the nested objects colName is in by the schema, outermost first
+func (e *ESql) nestedPaths(colName string) (paths []string) {
+	if e.schema == nil {
+		return nil
+	}
+	parts := strings.Split(colName, ".")
+	for i := 1; i < len(parts); i++ {
+		path := strings.Join(parts[:i], ".")
+		if mapping, exist := e.fieldMapping(path); exist && mapping.Type == "nested" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// nestedPath returns the innermost nested object colName is in, empty if colName is not nested
+func (e *ESql) nestedPath(colName string) string {
+	paths := e.nestedPaths(colName)
+	if len(paths) == 0 {
+		return ""
+	}
+	return paths[len(paths)-1]
+}
+
+// isNestedFunc checks NESTED(path, cond), an explicit predicate on the elements of a nested object
+func isNestedFunc(expr sqlparser.Expr) bool {
+	funcExpr, ok := expr.(*sqlparser.FuncExpr)
+	return ok && funcExpr.Name.EqualString("nested")
+}
+
+// nestedFuncArgs returns the path and the condition of NESTED(path, cond), path is a column or a string
+func (e *ESql) nestedFuncArgs(funcExpr *sqlparser.FuncExpr) (path string, cond sqlparser.Expr, err error) {
+	var args []sqlparser.Expr
+	for _, selectExpr := range funcExpr.Exprs {
+		if aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr); ok {
+			args = append(args, aliasedExpr.Expr)
+		}
+	}
+	if len(args) != 2 || len(funcExpr.Exprs) != 2 {
+		err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: NESTED requires a path and a condition`)
+		return "", nil, err
+	}
+	switch pathExpr := args[0].(type) {
+	case *sqlparser.ColName:
+		path, err = e.convertColName(pathExpr)
+		if err != nil {
+			return "", nil, err
+		}
+	case *sqlparser.SQLVal:
+		path = string(pathExpr.Val)
+	default:
+		err = errorf(ErrSyntax, sqlparser.String(funcExpr), `esql: NESTED path must be a column name`)
+		return "", nil, err
+	}
+	return path, args[1], nil
+}
+
+func newNestedFunc(path string, cond sqlparser.Expr) *sqlparser.FuncExpr {
+	return &sqlparser.FuncExpr{
+		Name: sqlparser.NewColIdent("nested"),
+		Exprs: sqlparser.SelectExprs{
+			&sqlparser.AliasedExpr{Expr: sqlparser.NewStrVal([]byte(path))},
+			&sqlparser.AliasedExpr{Expr: cond},
+		},
+	}
+}
+
+// childNestedPath returns the nested object under parent that all columns of expr are in, ok is false
+// if expr has no column, its columns are in different nested objects or in parent itself, or it has an
+// explicit NESTED. for items.parts.id under the root, the nested object is items
+func (e *ESql) childNestedPath(expr sqlparser.Expr, parent string) (path string, ok bool) {
+	seen := false
+	ok = true
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.FuncExpr:
+			// columns in a parent/child predicate are of other documents
+			if isNestedFunc(node) || isJoinFunc(node) {
+				ok = false
+			}
+		case *sqlparser.ColName:
+			colNameStr, err := e.convertColName(node)
+			if err != nil {
+				ok = false
+				break
+			}
+			colPath := ""
+			for _, nestedPath := range e.nestedPaths(colNameStr) {
+				if nestedPath != parent && isUnder(nestedPath, parent) {
+					colPath = nestedPath
+					break
+				}
+			}
+			if colPath == "" || seen && colPath != path {
+				ok = false
+			}
+			path, seen = colPath, true
+		}
+		return ok, nil
+	}, expr)
+	return path, ok && seen
+}
+
+// nestWhere groups the predicates of WHERE on the same nested object into NESTED(path, cond), so that
+// they match the same element of the nested object. parent is the nested object expr is in
+func (e *ESql) nestWhere(expr sqlparser.Expr, parent string) (sqlparser.Expr, error) {
+	if !e.hasNested(expr) {
+		return expr, nil
+	}
+	if path, ok := e.childNestedPath(expr, parent); ok {
+		return e.wrapNested(expr, path)
+	}
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		return e.nestConjuncts(expr, parent)
+	case *sqlparser.OrExpr:
+		lhs, err := e.nestWhere(expr.Left, parent)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := e.nestWhere(expr.Right, parent)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlparser.OrExpr{Left: lhs, Right: rhs}, nil
+	case *sqlparser.NotExpr:
+		inner, err := e.nestWhere(expr.Expr, parent)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlparser.NotExpr{Expr: inner}, nil
+	case *sqlparser.ParenExpr:
+		inner, err := e.nestWhere(expr.Expr, parent)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlparser.ParenExpr{Expr: inner}, nil
+	case *sqlparser.FuncExpr:
+		if !isNestedFunc(expr) {
+			return expr, nil
+		}
+		path, cond, err := e.nestedFuncArgs(expr)
+		if err != nil {
+			return nil, err
+		}
+		cond, err = e.nestWhere(cond, path)
+		if err != nil {
+			return nil, err
+		}
+		return newNestedFunc(path, cond), nil
+	}
+	return expr, nil
+}
+
+// nestConjuncts groups the conjuncts of andExpr by nested object, in the order they first appear
+func (e *ESql) nestConjuncts(andExpr *sqlparser.AndExpr, parent string) (sqlparser.Expr, error) {
+	if !e.hasNested(andExpr) {
+		return andExpr, nil
+	}
+	var items []sqlparser.Expr
+	groups := make(map[string]int)
+	var groupConds [][]sqlparser.Expr
+	var groupPaths []string
+	for _, conjunct := range flattenAnd(andExpr) {
+		if path, ok := e.childNestedPath(conjunct, parent); ok {
+			i, exist := groups[path]
+			if !exist {
+				i = len(groupConds)
+				groups[path] = i
+				groupConds = append(groupConds, nil)
+				groupPaths = append(groupPaths, path)
+				// a placeholder replaced by the group
+				items = append(items, nil)
+			}
+			groupConds[i] = append(groupConds[i], conjunct)
+			continue
+		}
+		nested, err := e.nestWhere(conjunct, parent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, nested)
+	}
+	var result sqlparser.Expr
+	group := 0
+	for _, item := range items {
+		if item == nil {
+			var err error
+			item, err = e.wrapNested(joinAnd(groupConds[group]), groupPaths[group])
+			if err != nil {
+				return nil, err
+			}
+			group++
+		}
+		if result == nil {
+			result = item
+		} else {
+			result = &sqlparser.AndExpr{Left: result, Right: item}
+		}
+	}
+	return result, nil
+}
+
+// hasNested checks expr has a column in a nested object or an explicit NESTED
+func (e *ESql) hasNested(expr sqlparser.Expr) (nested bool) {
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.FuncExpr:
+			nested = nested || isNestedFunc(node)
+		case *sqlparser.ColName:
+			if colNameStr, err := e.convertColName(node); err == nil {
+				nested = nested || e.nestedPath(colNameStr) != ""
+			}
+		}
+		return !nested, nil
+	}, expr)
+	return nested
+}
+
+// wrapNested returns NESTED(path, expr), predicates in nested objects under path are grouped as well
+func (e *ESql) wrapNested(expr sqlparser.Expr, path string) (sqlparser.Expr, error) {
+	cond, err := e.nestWhere(expr, path)
+	if err != nil {
+		return nil, err
+	}
+	return newNestedFunc(path, cond), nil
+}
+
+// isUnder checks path is in the nested object parent, every path is under the root
+func isUnder(path string, parent string) bool {
+	return parent == "" || strings.HasPrefix(path, parent+".")
+}
+
+func flattenAnd(expr sqlparser.Expr) []sqlparser.Expr {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		return append(flattenAnd(expr.Left), flattenAnd(expr.Right)...)
+	case *sqlparser.ParenExpr:
+		if _, ok := expr.Expr.(*sqlparser.AndExpr); ok {
+			return flattenAnd(expr.Expr)
+		}
+	}
+	return []sqlparser.Expr{expr}
+}
+
+func joinAnd(exprs []sqlparser.Expr) sqlparser.Expr {
+	result := exprs[0]
+	for _, expr := range exprs[1:] {
+		result = &sqlparser.AndExpr{Left: result, Right: expr}
+	}
+	return result
+}
+
+// convertNestedExpr converts NESTED(path, cond) to a nested query
+func (e *ESql) convertNestedExpr(expr sqlparser.Expr) (string, error) {
+	path, cond, err := e.nestedFuncArgs(expr.(*sqlparser.FuncExpr))
+	if err != nil {
+		return "", err
+	}
+	query, err := e.convertWhereExpr(cond, expr)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`{"nested": {"path": "%v", "query": %v}}`, path, query), nil
+}
+
+// nestedGroupPath returns the nested object the columns of GROUP BY are in, they must be in the same one
+func (e *ESql) nestedGroupPath(groupingSets [][]string) (path string, err error) {
+	seen := false
+	for _, groupingSet := range groupingSets {
+		for _, colNameStr := range groupingSet {
+			colPath := e.nestedPath(colNameStr)
+			if seen && colPath != path {
+				err = errorf(ErrUnsupported, colNameStr, `esql: GROUP BY columns in different nested objects not supported`)
+				return "", err
+			}
+			path, seen = colPath, true
+		}
+	}
+	return path, nil
+}
+
+// nestedFuncBody wraps the body of AGG(colName) by nestedAggBody
+func (e *ESql) nestedFuncBody(funcExpr sqlparser.FuncExpr, body string) (aggregation, error) {
+	if len(funcExpr.Exprs) != 1 {
+		return aggregation{body: body}, nil
+	}
+	aliasedExpr, ok := funcExpr.Exprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return aggregation{body: body}, nil
+	}
+	colName, ok := aliasedExpr.Expr.(*sqlparser.ColName)
+	if !ok {
+		return aggregation{body: body}, nil
+	}
+	colNameStr, err := e.convertColName(colName)
+	if err != nil {
+		return aggregation{}, err
+	}
+	return e.nestedAggBody(colNameStr, body)
+}
+
+// nestedAggBody wraps the metric body on colName by a nested aggregation if colName is in a nested
+// object under the buckets, or by a reverse_nested aggregation if the buckets are in a nested object
+func (e *ESql) nestedAggBody(colNameStr string, body string) (aggregation, error) {
+	path := e.nestedPath(colNameStr)
+	switch {
+	case path == e.aggPath:
+		return aggregation{body: body}, nil
+	case isUnder(path, e.aggPath):
+		body = fmt.Sprintf(`"nested": {"path": "%v"}, "aggs": {"value": {%v}}`, path, body)
+	case path == "":
+		body = fmt.Sprintf(`"reverse_nested": {}, "aggs": {"value": {%v}}`, body)
+	case isUnder(e.aggPath, path):
+		body = fmt.Sprintf(`"reverse_nested": {"path": "%v"}, "aggs": {"value": {%v}}`, path, body)
+	default:
+		err := errorf(ErrUnsupported, colNameStr, `esql: aggregation on %v in another nested object than GROUP BY not supported`, colNameStr)
+		return aggregation{}, err
+	}
+	return aggregation{body: body, path: ">value"}, nil
+}
@@ -0,0 +1,717 @@
+package esql
+
+import (
+	"math/big"
+	"strconv"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// ranges merged by the optimizer are RangeCond with these operators, BETWEEN includes both bounds
+const (
+	betweenOpenStr      = "between open"       // from < col < to
+	betweenLeftOpenStr  = "between left open"  // from < col <= to
+	betweenRightOpenStr = "between right open" // from <= col < to
+)
+
+// rangeInclusive returns whether the bounds of a RangeCond of operator op are inclusive
+func rangeInclusive(op string) (fromInclusive bool, toInclusive bool) {
+	switch op {
+	case betweenOpenStr:
+		return false, false
+	case betweenLeftOpenStr:
+		return false, true
+	case betweenRightOpenStr:
+		return true, false
+	default:
+		return true, true
+	}
+}
+
+// col op literal is the same as literal flippedOperator[op] col
+var flippedOperator = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	"<>": "<>",
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
+
+// optimize rewrites the WHERE expression before it is converted to dsl. it folds constants, moves
+// constants in comparisons to the literal side so that they become range queries instead of scripts,
+// removes duplicate predicates, merges ranges on a column in AND and equalities on a column in OR,
+// and replaces contradictions with FALSE. unchanged sub trees are returned as they are
+func (e *ESql) optimize(expr sqlparser.Expr) (optimized sqlparser.Expr, changed bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.ParenExpr:
+		inner, changed := e.optimize(expr.Expr)
+		if _, ok := inner.(sqlparser.BoolVal); ok {
+			return inner, true
+		}
+		if changed {
+			return &sqlparser.ParenExpr{Expr: inner}, true
+		}
+	case *sqlparser.NotExpr:
+		inner, changed := e.optimize(expr.Expr)
+		if boolVal, ok := inner.(sqlparser.BoolVal); ok {
+			return !boolVal, true
+		}
+		if changed {
+			return &sqlparser.NotExpr{Expr: inner}, true
+		}
+	case *sqlparser.AndExpr:
+		return e.optimizeAnd(expr)
+	case *sqlparser.OrExpr:
+		return e.optimizeOr(expr)
+	case *sqlparser.ComparisonExpr:
+		return e.optimizeComparison(expr)
+	case *sqlparser.RangeCond:
+		from, fromChanged := foldConstant(expr.From)
+		to, toChanged := foldConstant(expr.To)
+		if fromChanged || toChanged {
+			return &sqlparser.RangeCond{Operator: expr.Operator, Left: expr.Left, From: from, To: to}, true
+		}
+	}
+	return expr, false
+}
+
+// a AND (b AND c) -> [a, b, c], each optimized
+func (e *ESql) conjuncts(expr sqlparser.Expr) (exprs []sqlparser.Expr, changed bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		lhs, lhsChanged := e.conjuncts(expr.Left)
+		rhs, rhsChanged := e.conjuncts(expr.Right)
+		return append(lhs, rhs...), lhsChanged || rhsChanged
+	case *sqlparser.ParenExpr:
+		if _, ok := expr.Expr.(*sqlparser.AndExpr); ok {
+			return e.conjuncts(expr.Expr)
+		}
+	}
+	optimized, changed := e.optimize(expr)
+	return []sqlparser.Expr{optimized}, changed
+}
+
+// a OR (b OR c) -> [a, b, c], each optimized
+func (e *ESql) disjuncts(expr sqlparser.Expr) (exprs []sqlparser.Expr, changed bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.OrExpr:
+		lhs, lhsChanged := e.disjuncts(expr.Left)
+		rhs, rhsChanged := e.disjuncts(expr.Right)
+		return append(lhs, rhs...), lhsChanged || rhsChanged
+	case *sqlparser.ParenExpr:
+		if _, ok := expr.Expr.(*sqlparser.OrExpr); ok {
+			return e.disjuncts(expr.Expr)
+		}
+	}
+	optimized, changed := e.optimize(expr)
+	return []sqlparser.Expr{optimized}, changed
+}
+
+func (e *ESql) optimizeAnd(andExpr *sqlparser.AndExpr) (sqlparser.Expr, bool) {
+	exprs, changed := e.conjuncts(andExpr)
+	var kept []sqlparser.Expr
+	seen := make(map[string]int)
+	for _, expr := range exprs {
+		if boolVal, ok := expr.(sqlparser.BoolVal); ok {
+			if !boolVal {
+				return sqlparser.BoolVal(false), true
+			}
+			changed = true
+			continue
+		}
+		// duplicate predicates
+		if _, exist := seen[sqlparser.String(expr)]; exist {
+			changed = true
+			continue
+		}
+		seen[sqlparser.String(expr)] = 1
+		kept = append(kept, expr)
+	}
+	kept, merged, contradiction := e.mergeRanges(kept)
+	if contradiction {
+		e.explanation.addWarning(`contradiction in %v, the query matches no document`, sqlparser.String(andExpr))
+		return sqlparser.BoolVal(false), true
+	}
+	if !changed && !merged {
+		return andExpr, false
+	}
+	if len(kept) == 0 {
+		return sqlparser.BoolVal(true), true
+	}
+	result := kept[0]
+	for _, expr := range kept[1:] {
+		result = &sqlparser.AndExpr{Left: result, Right: expr}
+	}
+	return result, true
+}
+
+func (e *ESql) optimizeOr(orExpr *sqlparser.OrExpr) (sqlparser.Expr, bool) {
+	exprs, changed := e.disjuncts(orExpr)
+	var kept []sqlparser.Expr
+	seen := make(map[string]int)
+	for _, expr := range exprs {
+		if boolVal, ok := expr.(sqlparser.BoolVal); ok {
+			if boolVal {
+				return sqlparser.BoolVal(true), true
+			}
+			changed = true
+			continue
+		}
+		if _, exist := seen[sqlparser.String(expr)]; exist {
+			changed = true
+			continue
+		}
+		seen[sqlparser.String(expr)] = 1
+		kept = append(kept, expr)
+	}
+	kept, merged := e.mergeEqualities(kept)
+	if !changed && !merged {
+		return orExpr, false
+	}
+	if len(kept) == 0 {
+		return sqlparser.BoolVal(false), true
+	}
+	result := kept[0]
+	for _, expr := range kept[1:] {
+		result = &sqlparser.OrExpr{Left: result, Right: expr}
+	}
+	return result, true
+}
+
+// col = a OR col = b OR col IN (c, d) -> col IN (a, b, c, d), which is a single terms query
+func (e *ESql) mergeEqualities(exprs []sqlparser.Expr) (result []sqlparser.Expr, merged bool) {
+	type values struct {
+		index int
+		count int
+		col   *sqlparser.ColName
+		tuple sqlparser.ValTuple
+		seen  map[string]int
+	}
+	valuesMap := make(map[string]*values)
+	var colNameSlice []string
+	member := make(map[int]string)
+	for i, expr := range exprs {
+		comparisonExpr, ok := unparen(expr).(*sqlparser.ComparisonExpr)
+		if !ok || (comparisonExpr.Operator != sqlparser.EqualStr && comparisonExpr.Operator != sqlparser.InStr) {
+			continue
+		}
+		colName, ok := comparisonExpr.Left.(*sqlparser.ColName)
+		if !ok {
+			continue
+		}
+		tuple, ok := comparisonExpr.Right.(sqlparser.ValTuple)
+		if !ok {
+			tuple = sqlparser.ValTuple{comparisonExpr.Right}
+		}
+		literalsOnly := true
+		for _, valExpr := range tuple {
+			if lit, ok := convertLiteral(valExpr); !ok || (lit.typ == literalNull && comparisonExpr.Operator == sqlparser.EqualStr) {
+				literalsOnly = false
+			}
+		}
+		colNameStr, err := e.convertColName(colName)
+		if !literalsOnly || err != nil {
+			continue
+		}
+		v, exist := valuesMap[colNameStr]
+		if !exist {
+			v = &values{index: i, col: colName, seen: make(map[string]int)}
+			valuesMap[colNameStr] = v
+			colNameSlice = append(colNameSlice, colNameStr)
+		}
+		v.count++
+		member[i] = colNameStr
+		for _, valExpr := range tuple {
+			if _, exist := v.seen[sqlparser.String(valExpr)]; !exist {
+				v.seen[sqlparser.String(valExpr)] = 1
+				v.tuple = append(v.tuple, valExpr)
+			}
+		}
+	}
+
+	replaced := make(map[int]sqlparser.Expr)
+	for _, colNameStr := range colNameSlice {
+		v := valuesMap[colNameStr]
+		if v.count > 1 {
+			replaced[v.index] = &sqlparser.ComparisonExpr{Operator: sqlparser.InStr, Left: v.col, Right: v.tuple}
+		}
+	}
+	if len(replaced) == 0 {
+		return exprs, false
+	}
+	for i, expr := range exprs {
+		if newExpr, exist := replaced[i]; exist {
+			result = append(result, newExpr)
+			continue
+		}
+		if colNameStr, isMember := member[i]; isMember && valuesMap[colNameStr].count > 1 {
+			continue
+		}
+		result = append(result, expr)
+	}
+	return result, true
+}
+
+// numeric bound of a range
+type rangeBound struct {
+	expr      sqlparser.Expr
+	val       *big.Rat
+	inclusive bool
+}
+
+// mergeRanges merges range predicates on numbers of a column in AND to a single range, and checks
+// equalities on the column against the range. only literals comparableLiteral accepts are merged
+func (e *ESql) mergeRanges(exprs []sqlparser.Expr) (result []sqlparser.Expr, merged bool, contradiction bool) {
+	type columnRange struct {
+		index        int
+		count        int
+		col          *sqlparser.ColName
+		lower, upper *rangeBound
+		eq           sqlparser.Expr
+		eqLit        literal
+		neqs         []literal
+	}
+	rangeMap := make(map[string]*columnRange)
+	var colNameSlice []string
+	member := make(map[int]string)
+	for i, expr := range exprs {
+		var colName *sqlparser.ColName
+		var bounds []struct {
+			op   string
+			expr sqlparser.Expr
+		}
+		switch expr := unparen(expr).(type) {
+		case *sqlparser.ComparisonExpr:
+			colName, _ = expr.Left.(*sqlparser.ColName)
+			bounds = append(bounds, struct {
+				op   string
+				expr sqlparser.Expr
+			}{expr.Operator, expr.Right})
+		case *sqlparser.RangeCond:
+			if expr.Operator == sqlparser.NotBetweenStr {
+				continue
+			}
+			colName, _ = expr.Left.(*sqlparser.ColName)
+			fromInclusive, toInclusive := rangeInclusive(expr.Operator)
+			fromOp, toOp := ">=", "<="
+			if !fromInclusive {
+				fromOp = ">"
+			}
+			if !toInclusive {
+				toOp = "<"
+			}
+			bounds = append(bounds, struct {
+				op   string
+				expr sqlparser.Expr
+			}{fromOp, expr.From}, struct {
+				op   string
+				expr sqlparser.Expr
+			}{toOp, expr.To})
+		}
+		colNameStr, ok := e.optimizableColumn(colName)
+		if !ok {
+			continue
+		}
+
+		cr, exist := rangeMap[colNameStr]
+		if !exist {
+			cr = &columnRange{index: i, col: colName}
+		}
+		valid := true
+		next := *cr
+		for _, bound := range bounds {
+			lit, ok := convertLiteral(bound.expr)
+			if !ok || !e.comparableLiteral(colNameStr, lit) {
+				valid = false
+				break
+			}
+			isNumber := lit.typ == literalInt || lit.typ == literalFloat
+			switch bound.op {
+			case "=":
+				if next.eq != nil && literalCompare(next.eqLit, lit) != 0 {
+					contradiction = true
+				}
+				next.eq, next.eqLit = expr, lit
+			case "!=", "<>":
+				next.neqs = append(next.neqs, lit)
+			case ">", ">=", "<", "<=":
+				if !isNumber {
+					valid = false
+					break
+				}
+				val, _ := new(big.Rat).SetString(lit.val)
+				b := &rangeBound{expr: bound.expr, val: val, inclusive: len(bound.op) == 2}
+				if bound.op[0] == '>' {
+					next.lower = tighterBound(next.lower, b, 1)
+				} else {
+					next.upper = tighterBound(next.upper, b, -1)
+				}
+			default:
+				valid = false
+			}
+		}
+		if !valid {
+			continue
+		}
+		next.count++
+		*cr = next
+		if !exist {
+			rangeMap[colNameStr] = cr
+			colNameSlice = append(colNameSlice, colNameStr)
+		}
+		member[i] = colNameStr
+	}
+	if contradiction {
+		return nil, false, true
+	}
+
+	replaced := make(map[string]sqlparser.Expr)
+	for _, colNameStr := range colNameSlice {
+		cr := rangeMap[colNameStr]
+		lower, upper := cr.lower, cr.upper
+		if lower != nil && upper != nil {
+			cmp := lower.val.Cmp(upper.val)
+			if cmp > 0 || (cmp == 0 && !(lower.inclusive && upper.inclusive)) {
+				return nil, false, true
+			}
+		}
+		if cr.eq != nil {
+			for _, neq := range cr.neqs {
+				if literalCompare(cr.eqLit, neq) == 0 {
+					return nil, false, true
+				}
+			}
+			if lower != nil || upper != nil {
+				val, ok := new(big.Rat).SetString(cr.eqLit.val)
+				if !ok {
+					continue
+				}
+				if !inRange(val, lower, upper) {
+					return nil, false, true
+				}
+			}
+			// the equality implies the rest
+			replaced[colNameStr] = cr.eq
+			continue
+		}
+		if cr.count < 2 || len(cr.neqs) > 0 {
+			continue
+		}
+		replaced[colNameStr] = mergedRange(cr.col, lower, upper)
+	}
+	if len(replaced) == 0 {
+		return exprs, false, false
+	}
+
+	added := make(map[string]int)
+	for i, expr := range exprs {
+		colNameStr, isMember := member[i]
+		newExpr, isReplaced := replaced[colNameStr]
+		if !isMember || !isReplaced {
+			result = append(result, expr)
+			continue
+		}
+		if _, exist := added[colNameStr]; !exist {
+			added[colNameStr] = 1
+			result = append(result, newExpr)
+		}
+	}
+	return result, true, false
+}
+
+// tighterBound returns the tighter one of 2 lower bounds (sign 1) or upper bounds (sign -1)
+func tighterBound(current *rangeBound, b *rangeBound, sign int) *rangeBound {
+	if current == nil {
+		return b
+	}
+	cmp := b.val.Cmp(current.val) * sign
+	if cmp > 0 || (cmp == 0 && !b.inclusive) {
+		return b
+	}
+	return current
+}
+
+func inRange(val *big.Rat, lower *rangeBound, upper *rangeBound) bool {
+	if lower != nil {
+		cmp := val.Cmp(lower.val)
+		if cmp < 0 || (cmp == 0 && !lower.inclusive) {
+			return false
+		}
+	}
+	if upper != nil {
+		cmp := val.Cmp(upper.val)
+		if cmp > 0 || (cmp == 0 && !upper.inclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+func mergedRange(col *sqlparser.ColName, lower *rangeBound, upper *rangeBound) sqlparser.Expr {
+	switch {
+	case upper == nil:
+		op := ">"
+		if lower.inclusive {
+			op = ">="
+		}
+		return &sqlparser.ComparisonExpr{Operator: op, Left: col, Right: lower.expr}
+	case lower == nil:
+		op := "<"
+		if upper.inclusive {
+			op = "<="
+		}
+		return &sqlparser.ComparisonExpr{Operator: op, Left: col, Right: upper.expr}
+	}
+	op := sqlparser.BetweenStr
+	switch {
+	case !lower.inclusive && !upper.inclusive:
+		op = betweenOpenStr
+	case !lower.inclusive:
+		op = betweenLeftOpenStr
+	case !upper.inclusive:
+		op = betweenRightOpenStr
+	}
+	return &sqlparser.RangeCond{Operator: op, Left: col, From: lower.expr, To: upper.expr}
+}
+
+// comparableLiteral returns whether predicates of lit on colNameStr can be merged by literalCompare. numbers
+// are compared by value unless the schema maps the column to a type other than a number or a date, which
+// is in epoch millis then. strings are compared as they are only on keyword fields, a date, an ip or a
+// string of unknown type has other notations of the same value, e.g. '::1' and '0:0::1'
+func (e *ESql) comparableLiteral(colNameStr string, lit literal) bool {
+	mapping, exist := e.fieldMapping(colNameStr)
+	switch lit.typ {
+	case literalInt, literalFloat:
+		return !exist || numericTypes[mapping.Type] || mapping.Type == "date"
+	case literalString:
+		return exist && mapping.Type == "keyword"
+	default:
+		return false
+	}
+}
+
+// literalCompare returns 0 if 2 literals are equal, numbers are compared by value
+func literalCompare(a literal, b literal) int {
+	aNum := a.typ == literalInt || a.typ == literalFloat
+	bNum := b.typ == literalInt || b.typ == literalFloat
+	if aNum && bNum {
+		aVal, aOk := new(big.Rat).SetString(a.val)
+		bVal, bOk := new(big.Rat).SetString(b.val)
+		if aOk && bOk {
+			return aVal.Cmp(bVal)
+		}
+	}
+	if a.val == b.val {
+		return 0
+	}
+	return 1
+}
+
+// optimizableColumn returns the name of colName if predicates on it can be rewritten. values
+// of a column selected by the value macro can not, since the macro may change their order
+func (e *ESql) optimizableColumn(colName *sqlparser.ColName) (string, bool) {
+	if colName == nil {
+		return "", false
+	}
+	colNameStr, err := e.convertColName(colName)
+	if err != nil {
+		return "", false
+	}
+	if e.filterValue != nil && e.processValue != nil && e.filterValue(colNameStr) {
+		return "", false
+	}
+	return colNameStr, true
+}
+
+// optimizeComparison folds constants, turns 10 < col to col > 10, and moves constants added to or
+// multiplied with the column to the literal side, e.g. 2 * col + 1 > 9 -> col > 4
+func (e *ESql) optimizeComparison(comparisonExpr *sqlparser.ComparisonExpr) (sqlparser.Expr, bool) {
+	op := comparisonExpr.Operator
+	lhs, lhsChanged := foldConstant(comparisonExpr.Left)
+	rhs, rhsChanged := foldConstant(comparisonExpr.Right)
+	changed := lhsChanged || rhsChanged
+	if flipped, ok := flippedOperator[op]; ok && isLiteral(lhs) && !isLiteral(rhs) {
+		lhs, rhs, op = rhs, lhs, flipped
+		changed = true
+	}
+	_, isColName := lhs.(*sqlparser.ColName)
+	if _, ok := flippedOperator[op]; ok && !isColName && isNumber(rhs) {
+		if colExpr, colOp, val, ok := e.isolateColumn(lhs, op, rhs); ok {
+			lhs, op, rhs = colExpr, colOp, val
+			changed = true
+		}
+	}
+	if !changed {
+		return comparisonExpr, false
+	}
+	return &sqlparser.ComparisonExpr{Operator: op, Left: lhs, Right: rhs, Escape: comparisonExpr.Escape}, true
+}
+
+// isolateColumn solves expr op val for the column in expr, ok is false if expr is not a column
+// with constants added, subtracted or multiplied
+func (e *ESql) isolateColumn(expr sqlparser.Expr, op string, val sqlparser.Expr) (colExpr sqlparser.Expr, colOp string, colVal sqlparser.Expr, ok bool) {
+	expr = unparen(expr)
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		if _, optimizable := e.optimizableColumn(expr); !optimizable {
+			return nil, "", nil, false
+		}
+		return expr, op, val, true
+	case *sqlparser.UnaryExpr:
+		// -col op val -> col op' -val
+		if expr.Operator != sqlparser.UMinusStr {
+			return nil, "", nil, false
+		}
+		negated, ok := arith(sqlparser.MultStr, val, numberExpr(big.NewRat(-1, 1), false))
+		if !ok {
+			return nil, "", nil, false
+		}
+		return e.isolateColumn(expr.Expr, flippedOperator[op], negated)
+	case *sqlparser.BinaryExpr:
+		lhsIsNumber, rhsIsNumber := isNumber(expr.Left), isNumber(expr.Right)
+		if lhsIsNumber == rhsIsNumber {
+			return nil, "", nil, false
+		}
+		inner, constant := expr.Left, expr.Right
+		if lhsIsNumber {
+			inner, constant = expr.Right, expr.Left
+		}
+		var newVal sqlparser.Expr
+		newOp := op
+		switch expr.Operator {
+		case sqlparser.PlusStr:
+			newVal, ok = arith(sqlparser.MinusStr, val, constant)
+		case sqlparser.MinusStr:
+			if rhsIsNumber {
+				newVal, ok = arith(sqlparser.PlusStr, val, constant)
+			} else {
+				// constant - col op val -> col op' constant - val
+				newVal, ok = arith(sqlparser.MinusStr, constant, val)
+				newOp = flippedOperator[op]
+			}
+		case sqlparser.MultStr:
+			factor := numberValue(constant)
+			if factor.Sign() == 0 {
+				return nil, "", nil, false
+			}
+			newVal, ok = arith(sqlparser.DivStr, val, constant)
+			// a fraction can not be compared by term with an integer field
+			if lit, _ := convertLiteral(newVal); ok && lit.typ == literalFloat && isEquality(op) && !isFloat(val) && !isFloat(constant) {
+				return nil, "", nil, false
+			}
+			if factor.Sign() < 0 {
+				newOp = flippedOperator[op]
+			}
+		default:
+			return nil, "", nil, false
+		}
+		if !ok {
+			return nil, "", nil, false
+		}
+		return e.isolateColumn(inner, newOp, newVal)
+	}
+	return nil, "", nil, false
+}
+
+func isEquality(op string) bool {
+	return op == "=" || op == "!=" || op == "<>"
+}
+
+// foldConstant evaluates arithmetics of numeric literals, e.g. 8 + 2 -> 10
+func foldConstant(expr sqlparser.Expr) (folded sqlparser.Expr, changed bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.ParenExpr:
+		inner, changed := foldConstant(expr.Expr)
+		if isNumber(inner) {
+			return inner, true
+		}
+		if changed {
+			return &sqlparser.ParenExpr{Expr: inner}, true
+		}
+	case *sqlparser.BinaryExpr:
+		lhs, lhsChanged := foldConstant(expr.Left)
+		rhs, rhsChanged := foldConstant(expr.Right)
+		if isNumber(lhs) && isNumber(rhs) {
+			if folded, ok := arith(expr.Operator, lhs, rhs); ok {
+				return folded, true
+			}
+		}
+		if lhsChanged || rhsChanged {
+			return &sqlparser.BinaryExpr{Operator: expr.Operator, Left: lhs, Right: rhs}, true
+		}
+	}
+	return expr, false
+}
+
+// arith computes lhs op rhs of numeric literals, the result is an integer literal if it is an
+// integer and neither operand is a float
+func arith(op string, lhs sqlparser.Expr, rhs sqlparser.Expr) (sqlparser.Expr, bool) {
+	a, b := numberValue(lhs), numberValue(rhs)
+	if a == nil || b == nil {
+		return nil, false
+	}
+	result := new(big.Rat)
+	switch op {
+	case sqlparser.PlusStr:
+		result.Add(a, b)
+	case sqlparser.MinusStr:
+		result.Sub(a, b)
+	case sqlparser.MultStr:
+		result.Mul(a, b)
+	case sqlparser.DivStr:
+		if b.Sign() == 0 {
+			return nil, false
+		}
+		result.Quo(a, b)
+	default:
+		return nil, false
+	}
+	return numberExpr(result, isFloat(lhs) || isFloat(rhs)), true
+}
+
+func numberExpr(val *big.Rat, float bool) sqlparser.Expr {
+	if val.IsInt() && !float {
+		return &sqlparser.SQLVal{Type: sqlparser.IntVal, Val: []byte(val.Num().String())}
+	}
+	f, _ := val.Float64()
+	return &sqlparser.SQLVal{Type: sqlparser.FloatVal, Val: []byte(strconv.FormatFloat(f, 'f', -1, 64))}
+}
+
+// numberValue returns the value of a numeric literal, or nil
+func numberValue(expr sqlparser.Expr) *big.Rat {
+	lit, ok := convertLiteral(expr)
+	if !ok || (lit.typ != literalInt && lit.typ != literalFloat) {
+		return nil
+	}
+	val, ok := new(big.Rat).SetString(lit.val)
+	if !ok {
+		return nil
+	}
+	return val
+}
+
+func isNumber(expr sqlparser.Expr) bool {
+	return numberValue(expr) != nil
+}
+
+func isLiteral(expr sqlparser.Expr) bool {
+	lit, ok := convertLiteral(expr)
+	return ok && lit.typ != literalNull
+}
+
+func isFloat(expr sqlparser.Expr) bool {
+	lit, ok := convertLiteral(expr)
+	return ok && lit.typ == literalFloat
+}
+
+func unparen(expr sqlparser.Expr) sqlparser.Expr {
+	for {
+		parenExpr, ok := expr.(*sqlparser.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = parenExpr.Expr
+	}
+}
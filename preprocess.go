@@ -0,0 +1,340 @@
+package esql
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// sqlparser does not understand some standard SQL syntax, each sqlRewrite turns such a construct
+// into an equivalent function call that sqlparser accepts before the query is parsed
+type sqlRewrite func(sql string) (string, error)
+
+var sqlRewrites = []sqlRewrite{
+	rewriteGroupingSets,
+	rewriteAggregateFilter,
+	rewriteExtract,
+	rewriteFromTargets,
+	rewriteILike,
+	rewriteInCIDR,
+	rewriteLikeEscapes,
+}
+
+var groupingSetsRegexp = regexp.MustCompile(`(?i)\bGROUPING\s+SETS\s*\(`)
+var emptyParenRegexp = regexp.MustCompile(`\(\s*\)`)
+var filterWhereRegexp = regexp.MustCompile(`(?i)\bFILTER\s*\(\s*WHERE\b`)
+var setTimeZoneRegexp = regexp.MustCompile(`(?i)^\s*SET\s+(?:TIME\s+ZONE\s+|time_zone\s*=\s*)'([^']*)'\s*;`)
+var timeZoneHintRegexp = regexp.MustCompile(`(?i)/\*\+\s*TIME_ZONE\s*\(\s*'([^']*)'\s*\)\s*\*/`)
+var timeZoneRegexp = regexp.MustCompile(`^(?:[+-]\d{2}(?::?\d{2})?|[A-Za-z][A-Za-z0-9_+-]*(?:/[A-Za-z0-9_+-]+)*)$`)
+var extractRegexp = regexp.MustCompile(`(?i)\bEXTRACT\s*\(\s*([a-z_]+)\s+FROM\b`)
+var ilikeRegexp = regexp.MustCompile(`(?i)\bILIKE\s+`)
+var fromRegexp = regexp.MustCompile(`(?i)\bFROM\s+`)
+var fromAliasRegexp = regexp.MustCompile(`(?i)^(?:AS\s+)?[a-z_][a-z0-9_]*\s*,`)
+var inCIDRRegexp = regexp.MustCompile(`(?i)\bIN\s+CIDR\s*\(`)
+
+func preprocess(sql string) (string, error) {
+	var err error
+	for _, rewrite := range sqlRewrites {
+		sql, err = rewrite(sql)
+		if err != nil {
+			return "", err
+		}
+	}
+	return sql, nil
+}
+
+// extractTimeZone removes the time zone setting of the query from sql, which is either a
+// "SET TIME ZONE 'zone';" prefix or a /*+ TIME_ZONE('zone') */ hint
+func extractTimeZone(sql string) (string, string, error) {
+	var timeZone string
+	if loc := setTimeZoneRegexp.FindStringSubmatchIndex(sql); loc != nil {
+		timeZone = sql[loc[2]:loc[3]]
+		sql = sql[loc[1]:]
+	}
+	masked := maskQuoted(sql)
+	if loc := timeZoneHintRegexp.FindStringSubmatchIndex(masked); loc != nil {
+		if timeZone != "" {
+			err := errorf(ErrSyntax, sql[loc[0]:loc[1]], `esql: time zone is set more than once`)
+			return "", "", err
+		}
+		timeZone = sql[loc[2]:loc[3]]
+		sql = sql[:loc[0]] + sql[loc[1]:]
+	}
+	if timeZone != "" && !timeZoneRegexp.MatchString(timeZone) {
+		err := errorf(ErrSyntax, timeZone, `esql: invalid time zone %v`, timeZone)
+		return "", "", err
+	}
+	return sql, timeZone, nil
+}
+
+// GROUPING SETS ((a, b), (a), ()) -> grouping_sets((a, b), (a), (null))
+func rewriteGroupingSets(sql string) (string, error) {
+	for {
+		masked := maskQuoted(sql)
+		loc := groupingSetsRegexp.FindStringIndex(masked)
+		if loc == nil {
+			return sql, nil
+		}
+		open := loc[1] - 1
+		end := matchParen(masked, open)
+		if end < 0 {
+			err := errorf(ErrSyntax, sql[loc[0]:loc[1]], `esql: unbalanced parenthesis in GROUPING SETS`)
+			return "", err
+		}
+		sets := emptyParenRegexp.ReplaceAllString(sql[open+1:end], "(null)")
+		sql = sql[:loc[0]] + "grouping_sets(" + sets + sql[end:]
+	}
+}
+
+// COUNT(*) FILTER (WHERE cond) -> aggregate_filter(COUNT(*), cond)
+func rewriteAggregateFilter(sql string) (string, error) {
+	for {
+		masked := maskQuoted(sql)
+		loc := filterWhereRegexp.FindStringIndex(masked)
+		if loc == nil {
+			return sql, nil
+		}
+		open := loc[0] + strings.Index(masked[loc[0]:], "(")
+		end := matchParen(masked, open)
+		if end < 0 {
+			err := errorf(ErrSyntax, sql[loc[0]:loc[1]], `esql: unbalanced parenthesis in FILTER`)
+			return "", err
+		}
+		// the aggregation function FILTER applies to ends right before FILTER
+		aggEnd := len(strings.TrimRight(masked[:loc[0]], " \t\r\n")) - 1
+		if aggEnd < 0 || masked[aggEnd] != ')' {
+			err := errorf(ErrSyntax, sql[loc[0]:loc[1]], `esql: FILTER must follow an aggregation function`)
+			return "", err
+		}
+		aggOpen := matchParenBackward(masked, aggEnd)
+		if aggOpen < 0 {
+			err := errorf(ErrSyntax, sql[loc[0]:loc[1]], `esql: unbalanced parenthesis before FILTER`)
+			return "", err
+		}
+		nameEnd := len(strings.TrimRight(masked[:aggOpen], " \t\r\n"))
+		aggStart := nameEnd
+		for aggStart > 0 && isIdentChar(masked[aggStart-1]) {
+			aggStart--
+		}
+		if aggStart == nameEnd {
+			err := errorf(ErrSyntax, sql[loc[0]:loc[1]], `esql: FILTER must follow an aggregation function`)
+			return "", err
+		}
+		sql = sql[:aggStart] + "aggregate_filter(" + sql[aggStart:aggEnd+1] + "," + sql[loc[1]:end] + ")" + sql[end+1:]
+	}
+}
+
+// EXTRACT(YEAR FROM colA) -> extract('year', colA)
+func rewriteExtract(sql string) (string, error) {
+	for {
+		loc := extractRegexp.FindStringSubmatchIndex(maskQuoted(sql))
+		if loc == nil {
+			return sql, nil
+		}
+		unit := strings.ToLower(sql[loc[2]:loc[3]])
+		sql = sql[:loc[0]] + "extract('" + unit + "'," + sql[loc[1]:]
+	}
+}
+
+// FROM logs-*, logs-2020.01 -> FROM `logs-*`, `logs-2020.01`. index names and patterns w/ characters
+// other than letters, digits and _ are quoted, so that sqlparser takes each of them as a table name
+func rewriteFromTargets(sql string) (string, error) {
+	masked := maskQuoted(sql)
+	var buf bytes.Buffer
+	last := 0
+	for _, loc := range fromRegexp.FindAllStringIndex(masked, -1) {
+		i := loc[1]
+		for i < len(masked) {
+			start := i
+			if masked[i] == '`' {
+				end := strings.IndexByte(masked[i+1:], '`')
+				if end < 0 {
+					break
+				}
+				i += end + 2
+			} else {
+				// a target ends at a space, a comma, a parenthesis or a quote
+				for i < len(masked) && !strings.ContainsRune(" \t\r\n,();'\"`", rune(masked[i])) {
+					i++
+				}
+				target := sql[start:i]
+				if isQuotedTarget(target) {
+					buf.WriteString(sql[last:start] + "`" + target + "`")
+					last = i
+				}
+			}
+			// the next target follows a comma, after the alias of this one if any
+			comma := skipSpaces(masked, i)
+			if alias := fromAliasRegexp.FindStringIndex(masked[comma:]); alias != nil {
+				comma += alias[1] - 1
+			}
+			if comma >= len(masked) || masked[comma] != ',' {
+				break
+			}
+			i = skipSpaces(masked, comma+1)
+		}
+	}
+	buf.WriteString(sql[last:])
+	return buf.String(), nil
+}
+
+// isQuotedTarget checks target in FROM is an index name or pattern that needs quoting. a sign
+// starts a number, e.g. SUBSTRING(colA FROM -1)
+func isQuotedTarget(target string) bool {
+	if target == "" || target[0] == '-' || target[0] == '+' {
+		return false
+	}
+	for i := 0; i < len(target); i++ {
+		if !isIdentChar(target[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// colA ILIKE 'pattern' -> colA LIKE ilike('pattern')
+func rewriteILike(sql string) (string, error) {
+	for {
+		masked := maskQuoted(sql)
+		loc := ilikeRegexp.FindStringIndex(masked)
+		if loc == nil {
+			return sql, nil
+		}
+		if loc[1] >= len(masked) || (masked[loc[1]] != '\'' && masked[loc[1]] != '"') {
+			err := errorf(ErrUnsupported, sql[loc[0]:], `esql: ILIKE only supports a string pattern`)
+			return "", err
+		}
+		end := strings.IndexByte(masked[loc[1]+1:], masked[loc[1]])
+		if end < 0 {
+			err := errorf(ErrSyntax, sql[loc[0]:], `esql: unterminated string after ILIKE`)
+			return "", err
+		}
+		end += loc[1] + 1
+		sql = sql[:loc[0]] + "LIKE ilike(" + sql[loc[1]:end+1] + ")" + sql[end+1:]
+	}
+}
+
+// colA IN CIDR('10.0.0.0/8') -> colA IN (cidr('10.0.0.0/8'))
+func rewriteInCIDR(sql string) (string, error) {
+	for {
+		masked := maskQuoted(sql)
+		loc := inCIDRRegexp.FindStringIndex(masked)
+		if loc == nil {
+			return sql, nil
+		}
+		open := loc[1] - 1
+		end := matchParen(masked, open)
+		if end < 0 {
+			err := errorf(ErrSyntax, sql[loc[0]:loc[1]], `esql: unbalanced parenthesis in CIDR`)
+			return "", err
+		}
+		sql = sql[:loc[0]] + "IN (cidr(" + sql[open+1:end] + "))" + sql[end+1:]
+	}
+}
+
+// 'a\_b' -> 'a\\_b'. as in MySQL, \% and \_ in strings keep the backslash, so that LIKE sees
+// the escaped wildcard, while sqlparser drops the backslash of unknown escape sequences
+func rewriteLikeEscapes(sql string) (string, error) {
+	var buf bytes.Buffer
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		buf.WriteByte(c)
+		switch {
+		case quote == 0 && (c == '\'' || c == '"' || c == '`'):
+			quote = c
+		case quote != 0 && c == '\\' && quote != '`' && i+1 < len(sql):
+			i++
+			if sql[i] == '%' || sql[i] == '_' {
+				buf.WriteByte('\\')
+			}
+			buf.WriteByte(sql[i])
+		case quote != 0 && c == quote:
+			// doubled quote is an escaped quote
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				buf.WriteByte(sql[i])
+			} else {
+				quote = 0
+			}
+		}
+	}
+	return buf.String(), nil
+}
+
+// maskQuoted replaces the content of quoted strings and identifiers with 'x' so that keywords
+// and parenthesis inside them are ignored when scanning, the length of the sql is unchanged
+func maskQuoted(sql string) string {
+	masked := []byte(sql)
+	var quote byte
+	for i := 0; i < len(masked); i++ {
+		c := masked[i]
+		switch {
+		case quote == 0 && (c == '\'' || c == '"' || c == '`'):
+			quote = c
+		case quote != 0 && c == '\\' && quote != '`':
+			masked[i] = 'x'
+			if i+1 < len(masked) {
+				i++
+				masked[i] = 'x'
+			}
+		case quote != 0 && c == quote:
+			// doubled quote is an escaped quote
+			if i+1 < len(masked) && masked[i+1] == quote {
+				masked[i], masked[i+1] = 'x', 'x'
+				i++
+			} else {
+				quote = 0
+			}
+		case quote != 0:
+			masked[i] = 'x'
+		}
+	}
+	return string(masked)
+}
+
+// matchParen returns the index of the parenthesis that closes the one at open, or -1
+func matchParen(masked string, open int) int {
+	depth := 0
+	for i := open; i < len(masked); i++ {
+		switch masked[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// matchParenBackward returns the index of the parenthesis that opens the one at end, or -1
+func matchParenBackward(masked string, end int) int {
+	depth := 0
+	for i := end; i >= 0; i-- {
+		switch masked[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// skipSpaces returns the index of the first non space character from i
+func skipSpaces(masked string, i int) int {
+	for i < len(masked) && strings.IndexByte(" \t\r\n", masked[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,196 @@
+package esql
+
+import (
+	"fmt"
+	neturl "net/url"
+	"path"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// SearchRequest ...
+// SearchRequest is a search request converted from sql. Index, Routing and IgnoreUnavailable are request
+// level parameters, Body is the dsl
+type SearchRequest struct {
+	Index             []string
+	Routing           []string
+	IgnoreUnavailable bool // set if Index has time indices, which may not exist for periods w/o documents
+	Body              string
+	SortField         []string
+	ungrouped         bool // the query aggregates w/o GROUP BY, so its rows are neither hits nor buckets
+}
+
+// Path returns the url path of the request w/ its query string, e.g. /index1,index2/_search?routing=a
+func (r *SearchRequest) Path() string {
+	var indices []string
+	for _, index := range r.Index {
+		indices = append(indices, neturl.PathEscape(index))
+	}
+	path := "/" + strings.Join(indices, ",") + "/_search"
+	var params []string
+	if len(r.Routing) > 0 {
+		var routings []string
+		for _, routing := range r.Routing {
+			routings = append(routings, neturl.QueryEscape(routing))
+		}
+		params = append(params, "routing="+strings.Join(routings, ","))
+	}
+	if r.IgnoreUnavailable {
+		params = append(params, "ignore_unavailable=true")
+	}
+	if len(params) > 0 {
+		path += "?" + strings.Join(params, "&")
+	}
+	return path
+}
+
+// ConvertRequest ...
+// Transform sql to an elasticsearch search request
+//
+// usage:
+//  - request, err := e.ConvertRequest(sql, pageParam1, pageParam2, ...)
+//
+// arguments:
+//  - sql: the sql query needs conversion in string format
+//  - pagination: variadic arguments that indicates es search_after
+//
+// return values:
+//  - request: the indices and the routing to search, the dsl and the sort fields. _index and _routing
+//    predicates in WHERE that every document must match become request.Index and request.Routing,
+//    otherwise request.Index is the indices FROM resolves to, w/ the patterns of time indices pruned
+//    by the time range of WHERE
+//  - err: contains err information
+func (e *ESql) ConvertRequest(sql string, pagination ...interface{}) (request *SearchRequest, err error) {
+	conv, stmt, err := e.prepare(sql)
+	if err != nil {
+		return nil, locateError(sql, err)
+	}
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		request, err = conv.convertRequest(*stmt, pagination...)
+	case *sqlparser.Union:
+		err = errorf(ErrUnsupported, "UNION", `esql: use ConvertUnion for UNION ALL queries`)
+	default:
+		err = errorf(ErrUnsupported, "", `esql: Queries other than select not supported`)
+	}
+	if err != nil {
+		return nil, locateError(sql, err)
+	}
+	return request, nil
+}
+
+// convertRequest converts sel to a search request, e is the copy of e for this query by prepare
+func (e *ESql) convertRequest(sel sqlparser.Select, pagination ...interface{}) (request *SearchRequest, err error) {
+	request = &SearchRequest{}
+	e.request = request
+	request.Body, request.SortField, err = e.convertSelect(sel, "", pagination...)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Index) == 0 {
+		request.Index = e.indices
+	}
+	return request, nil
+}
+
+// liftRequestParams moves _index predicates that are conjuncts of WHERE to the request and copies _routing
+// ones, which stay in the query since a shard holds documents of other routings as well. the first predicate
+// on each of them is lifted and the others are left as they are. expr is nil if all its conjuncts are lifted.
+// only the indices FROM resolves to can be searched, an _index predicate w/o any of them stays in the query
+func (e *ESql) liftRequestParams(expr sqlparser.Expr) sqlparser.Expr {
+	var kept []sqlparser.Expr
+	for _, conjunct := range flattenAnd(expr) {
+		colNameStr, values, ok := e.requestParam(conjunct)
+		if ok && colNameStr == "_index" {
+			values = e.fromIndices(values)
+		}
+		switch {
+		case ok && colNameStr == "_index" && e.request.Index == nil && len(values) > 0:
+			e.request.Index = values
+		case ok && colNameStr == "_routing" && e.request.Routing == nil:
+			e.request.Routing = values
+			kept = append(kept, conjunct)
+		default:
+			kept = append(kept, conjunct)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return joinAnd(kept)
+}
+
+// fromIndices returns the indices that FROM resolves to among indices, an index of FROM may be a pattern
+func (e *ESql) fromIndices(indices []string) (matched []string) {
+	for _, index := range indices {
+		for _, fromIndex := range e.indices {
+			if ok, _ := path.Match(fromIndex, index); ok {
+				matched = append(matched, index)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// requestParam returns the column and the values of _index = 'v' or _index IN ('v1', 'v2'), and so for _routing
+func (e *ESql) requestParam(expr sqlparser.Expr) (colNameStr string, values []string, ok bool) {
+	comparisonExpr, isComparison := expr.(*sqlparser.ComparisonExpr)
+	if !isComparison {
+		return "", nil, false
+	}
+	colName, isColName := comparisonExpr.Left.(*sqlparser.ColName)
+	if !isColName {
+		return "", nil, false
+	}
+	colNameStr, err := e.convertColName(colName)
+	if err != nil || (colNameStr != "_index" && colNameStr != "_routing") {
+		return "", nil, false
+	}
+	var valExprs []sqlparser.Expr
+	switch comparisonExpr.Operator {
+	case sqlparser.EqualStr:
+		valExprs = []sqlparser.Expr{comparisonExpr.Right}
+	case sqlparser.InStr:
+		tuple, isTuple := comparisonExpr.Right.(sqlparser.ValTuple)
+		if !isTuple {
+			return "", nil, false
+		}
+		valExprs = tuple
+	default:
+		return "", nil, false
+	}
+	for _, valExpr := range valExprs {
+		lit, isLit := convertLiteral(valExpr)
+		if !isLit || lit.typ == literalNull {
+			return "", nil, false
+		}
+		lit, err = e.processLiteral(colNameStr, lit)
+		if err != nil {
+			return "", nil, false
+		}
+		values = append(values, lit.val)
+	}
+	return colNameStr, values, true
+}
+
+// convertIDsExpr converts a comparison on _id to an ids query, ok is false if op is not = or IN and
+// its negation
+func (e *ESql) convertIDsExpr(op string, lits []literal) (dsl string, ok bool) {
+	var ids []string
+	for _, lit := range lits {
+		if lit.typ != literalNull {
+			ids = append(ids, jsonString(lit.val))
+		}
+	}
+	dsl = fmt.Sprintf(`{"ids": {"values": [%v]}}`, strings.Join(ids, ", "))
+	switch op {
+	case "=", "in":
+		return dsl, true
+	case "<>", "!=", "not in":
+		// every document has an id, there is no need to check it exists
+		return fmt.Sprintf(`{"bool": {"must_not": %v}}`, dsl), true
+	}
+	return "", false
+}
@@ -0,0 +1,236 @@
+package esql
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldMapping ...
+// FieldMapping is the es type of a field, and the types of its multi-fields by name
+type FieldMapping struct {
+	Type   string
+	Fields map[string]string
+}
+
+// Schema ...
+// esql use Schema to look up the mappings of fields in the index being queried,
+// exist is false if the field is unknown, in which case the field is translated as is
+type Schema interface {
+	FieldMapping(index string, field string) (mapping FieldMapping, exist bool)
+}
+
+// MappingSchema ...
+// MappingSchema is a Schema built from the response of GET <index>/_mapping, field mappings by index
+// and flattened field name, e.g. "address.city"
+type MappingSchema map[string]map[string]FieldMapping
+
+type mappingProperty struct {
+	Type       string                     `json:"type"`
+	Properties map[string]mappingProperty `json:"properties"`
+	Fields     map[string]mappingProperty `json:"fields"`
+}
+
+// NewMappingSchema ...
+// Build a MappingSchema from the json response of GET <index>/_mapping, both typed (ES V6) and
+// typeless (ES V7) mappings are accepted
+func NewMappingSchema(mappingJSON []byte) (MappingSchema, error) {
+	var indices map[string]struct {
+		Mappings map[string]json.RawMessage `json:"mappings"`
+	}
+	if err := json.Unmarshal(mappingJSON, &indices); err != nil {
+		return nil, errorf(ErrResponse, "", `esql: invalid mapping: %v`, err)
+	}
+	schema := make(MappingSchema)
+	for index, indexMapping := range indices {
+		fields := make(map[string]FieldMapping)
+		var typeMappings []json.RawMessage
+		if _, typeless := indexMapping.Mappings["properties"]; typeless {
+			raw, _ := json.Marshal(indexMapping.Mappings)
+			typeMappings = append(typeMappings, raw)
+		} else {
+			for _, raw := range indexMapping.Mappings {
+				typeMappings = append(typeMappings, raw)
+			}
+		}
+		for _, raw := range typeMappings {
+			var typeMapping mappingProperty
+			if err := json.Unmarshal(raw, &typeMapping); err != nil {
+				return nil, errorf(ErrResponse, "", `esql: invalid mapping of index %v: %v`, index, err)
+			}
+			flattenMapping("", typeMapping.Properties, fields)
+		}
+		schema[index] = fields
+	}
+	return schema, nil
+}
+
+// LoadMappingSchema ...
+// Build a MappingSchema from a file that contains the json response of GET <index>/_mapping
+func LoadMappingSchema(path string) (MappingSchema, error) {
+	mappingJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMappingSchema(mappingJSON)
+}
+
+func flattenMapping(prefix string, properties map[string]mappingProperty, fields map[string]FieldMapping) {
+	for name, property := range properties {
+		field := prefix + name
+		mapping := FieldMapping{Type: property.Type}
+		if mapping.Type == "" {
+			mapping.Type = "object"
+		}
+		for subName, subProperty := range property.Fields {
+			if mapping.Fields == nil {
+				mapping.Fields = make(map[string]string)
+			}
+			mapping.Fields[subName] = subProperty.Type
+		}
+		fields[field] = mapping
+		flattenMapping(field+".", property.Properties, fields)
+	}
+}
+
+// FieldMapping ... look up field in index. if index is not in the schema, e.g. it is an alias, a pattern
+// or a comma separated list, the first index that has the field in alphabetical order is used, among the
+// indices index matches if there is any
+func (s MappingSchema) FieldMapping(index string, field string) (mapping FieldMapping, exist bool) {
+	if fields, ok := s[index]; ok {
+		mapping, exist = fields[field]
+		return mapping, exist
+	}
+	var indexSlice, matchedSlice []string
+	for name := range s {
+		indexSlice = append(indexSlice, name)
+		for _, pattern := range strings.Split(index, ",") {
+			if matched, _ := path.Match(pattern, name); matched {
+				matchedSlice = append(matchedSlice, name)
+				break
+			}
+		}
+	}
+	if len(matchedSlice) > 0 {
+		indexSlice = matchedSlice
+	}
+	sort.Strings(indexSlice)
+	for _, index := range indexSlice {
+		if mapping, exist = s[index][field]; exist {
+			return mapping, exist
+		}
+	}
+	return FieldMapping{}, false
+}
+
+var numericTypes = map[string]bool{
+	"long": true, "integer": true, "short": true, "byte": true, "unsigned_long": true,
+	"double": true, "float": true, "half_float": true, "scaled_float": true,
+}
+
+// fieldMapping looks up colName in the index being queried
+func (e *ESql) fieldMapping(colName string) (mapping FieldMapping, exist bool) {
+	if e.schema == nil {
+		return FieldMapping{}, false
+	}
+	return e.schema.FieldMapping(e.index, colName)
+}
+
+// keywordField returns the field for exact match, sorting, aggregations and scripts on colName,
+// which is the keyword sub field of a text field. exact is false for text w/o keyword sub field
+func (e *ESql) keywordField(colName string) (field string, exact bool) {
+	mapping, exist := e.fieldMapping(colName)
+	if !exist || mapping.Type != "text" {
+		return colName, true
+	}
+	if mapping.Fields["keyword"] == "keyword" {
+		return colName + ".keyword", true
+	}
+	var subNames []string
+	for subName, subType := range mapping.Fields {
+		if subType == "keyword" {
+			subNames = append(subNames, subName)
+		}
+	}
+	if len(subNames) == 0 {
+		return colName, false
+	}
+	sort.Strings(subNames)
+	return colName + "." + subNames[0], true
+}
+
+// aggField returns the field that funcName aggregates on colName. text fields can not be
+// aggregated in es, the keyword sub field is used for counting and grouping if there is one
+func (e *ESql) aggField(funcName string, colName string) (field string, err error) {
+	mapping, exist := e.fieldMapping(colName)
+	if !exist {
+		return colName, nil
+	}
+	switch funcName {
+	case "count", "cardinality", "group by":
+		if field, exact := e.keywordField(colName); exact {
+			return field, nil
+		}
+		err = errorf(ErrPolicy, colName, `esql: %v on text field %v w/o keyword sub field not supported`, funcName, colName)
+	case "sum", "avg":
+		if numericTypes[mapping.Type] {
+			return colName, nil
+		}
+		err = errorf(ErrPolicy, colName, `esql: %v on %v field %v not supported`, funcName, mapping.Type, colName)
+	default:
+		if numericTypes[mapping.Type] || mapping.Type == "date" {
+			return colName, nil
+		}
+		err = errorf(ErrPolicy, colName, `esql: %v on %v field %v not supported`, funcName, mapping.Type, colName)
+	}
+	return "", err
+}
+
+// scriptField returns the field to read in painless for colName, text fields have no doc values
+func (e *ESql) scriptField(colName string) (field string, err error) {
+	field, exact := e.keywordField(colName)
+	if !exact {
+		err = errorf(ErrPolicy, colName, `esql: text field %v w/o keyword sub field can not be used in scripts`, colName)
+		return "", err
+	}
+	return field, nil
+}
+
+// coerceLiteral converts lit to the type of colName, e.g. '10' to 10 for a long field
+func (e *ESql) coerceLiteral(colName string, lit literal) (literal, error) {
+	mapping, exist := e.fieldMapping(colName)
+	if !exist || lit.typ == literalNull {
+		return lit, nil
+	}
+	switch {
+	case numericTypes[mapping.Type]:
+		if lit.typ == literalInt || lit.typ == literalFloat {
+			return lit, nil
+		}
+		if _, err := strconv.ParseInt(lit.val, 10, 64); err == nil {
+			return literal{literalInt, lit.val}, nil
+		}
+		if _, err := strconv.ParseFloat(lit.val, 64); err == nil {
+			return literal{literalFloat, lit.val}, nil
+		}
+	case mapping.Type == "boolean":
+		switch lit.val {
+		case "true", "false":
+			return literal{literalBool, lit.val}, nil
+		}
+	case mapping.Type == "keyword" || mapping.Type == "text":
+		return literal{literalString, lit.val}, nil
+	case mapping.Type == "ip":
+		// addresses and CIDR blocks are kept as written
+		if lit.typ == literalString && isIPValue(lit.val) {
+			return lit, nil
+		}
+	default:
+		return lit, nil
+	}
+	err := errorf(ErrPolicy, lit.json(), `esql: invalid value %v for %v field %v`, lit.json(), mapping.Type, colName)
+	return literal{}, err
+}
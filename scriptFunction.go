@@ -0,0 +1,309 @@
+package esql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// scriptType is the type of a painless expression, used to check arguments of scalar functions
+type scriptType int
+
+const (
+	typeAny scriptType = iota
+	typeNumber
+	typeString
+	typeBool
+)
+
+func (t scriptType) String() string {
+	switch t {
+	case typeNumber:
+		return "number"
+	case typeString:
+		return "string"
+	case typeBool:
+		return "bool"
+	default:
+		return "any"
+	}
+}
+
+// scriptFunc is a scalar function evaluated in painless
+type scriptFunc struct {
+	minArgs  int
+	maxArgs  int          // -1 is unlimited
+	argTypes []scriptType // the last type applies to all the remaining arguments
+	retType  scriptType
+	// translate builds the painless script from the scripts of the arguments, which are never null.
+	// if any argument is null the function returns null. nil for functions that handle null themselves
+	translate func(args []string) string
+}
+
+func (f scriptFunc) argType(i int) scriptType {
+	if len(f.argTypes) == 0 {
+		return typeAny
+	}
+	if i >= len(f.argTypes) {
+		return f.argTypes[len(f.argTypes)-1]
+	}
+	return f.argTypes[i]
+}
+
+var scriptFuncs = map[string]scriptFunc{
+	// null handling
+	"coalesce": {minArgs: 1, maxArgs: -1},
+	"ifnull":   {minArgs: 2, maxArgs: 2},
+	"nullif":   {minArgs: 2, maxArgs: 2},
+	// string
+	"lower":       {1, 1, []scriptType{typeString}, typeString, painlessMethod("toLowerCase")},
+	"upper":       {1, 1, []scriptType{typeString}, typeString, painlessMethod("toUpperCase")},
+	"trim":        {1, 1, []scriptType{typeString}, typeString, painlessMethod("trim")},
+	"length":      {1, 1, []scriptType{typeString}, typeNumber, painlessMethod("length")},
+	"char_length": {1, 1, []scriptType{typeString}, typeNumber, painlessMethod("length")},
+	"concat":      {1, -1, []scriptType{typeAny}, typeString, painlessConcat},
+	"replace":     {3, 3, []scriptType{typeString}, typeString, painlessReplace},
+	// math
+	"abs":      {1, 1, []scriptType{typeNumber}, typeNumber, painlessMath("abs")},
+	"sign":     {1, 1, []scriptType{typeNumber}, typeNumber, painlessMath("signum")},
+	"floor":    {1, 1, []scriptType{typeNumber}, typeNumber, painlessMath("floor")},
+	"ceil":     {1, 1, []scriptType{typeNumber}, typeNumber, painlessMath("ceil")},
+	"ceiling":  {1, 1, []scriptType{typeNumber}, typeNumber, painlessMath("ceil")},
+	"sqrt":     {1, 1, []scriptType{typeNumber}, typeNumber, painlessMath("sqrt")},
+	"exp":      {1, 1, []scriptType{typeNumber}, typeNumber, painlessMath("exp")},
+	"ln":       {1, 1, []scriptType{typeNumber}, typeNumber, painlessMath("log")},
+	"log10":    {1, 1, []scriptType{typeNumber}, typeNumber, painlessMath("log10")},
+	"log":      {1, 2, []scriptType{typeNumber}, typeNumber, painlessLog},
+	"power":    {2, 2, []scriptType{typeNumber}, typeNumber, painlessMath("pow")},
+	"pow":      {2, 2, []scriptType{typeNumber}, typeNumber, painlessMath("pow")},
+	"mod":      {2, 2, []scriptType{typeNumber}, typeNumber, painlessMod},
+	"round":    {1, 2, []scriptType{typeNumber}, typeNumber, painlessRound},
+	"greatest": {1, -1, []scriptType{typeNumber}, typeNumber, painlessFold("max")},
+	"least":    {1, -1, []scriptType{typeNumber}, typeNumber, painlessFold("min")},
+}
+
+func painlessMethod(method string) func(args []string) string {
+	return func(args []string) string {
+		return fmt.Sprintf(`%v.%v()`, args[0], method)
+	}
+}
+
+func painlessMath(method string) func(args []string) string {
+	return func(args []string) string {
+		return fmt.Sprintf(`Math.%v(%v)`, method, strings.Join(args, ", "))
+	}
+}
+
+// GREATEST(a, b, c) -> Math.max(Math.max(a, b), c)
+func painlessFold(method string) func(args []string) string {
+	return func(args []string) string {
+		script := args[0]
+		for _, arg := range args[1:] {
+			script = fmt.Sprintf(`Math.%v(%v, %v)`, method, script, arg)
+		}
+		return script
+	}
+}
+
+func painlessConcat(args []string) string {
+	var argSlice []string
+	for _, arg := range args {
+		argSlice = append(argSlice, fmt.Sprintf(`String.valueOf(%v)`, arg))
+	}
+	return fmt.Sprintf(`(%v)`, strings.Join(argSlice, " + "))
+}
+
+func painlessReplace(args []string) string {
+	return fmt.Sprintf(`%v.replace(%v, %v)`, args[0], args[1], args[2])
+}
+
+// LOG(x) is the natural logarithm, LOG(b, x) is the logarithm of x to base b
+func painlessLog(args []string) string {
+	if len(args) == 1 {
+		return fmt.Sprintf(`Math.log(%v)`, args[0])
+	}
+	return fmt.Sprintf(`(Math.log(%v) / Math.log(%v))`, args[1], args[0])
+}
+
+func painlessMod(args []string) string {
+	return fmt.Sprintf(`(%v %% %v)`, args[0], args[1])
+}
+
+func painlessRound(args []string) string {
+	if len(args) == 1 {
+		return fmt.Sprintf(`Math.round(%v)`, args[0])
+	}
+	return fmt.Sprintf(`(Math.round(%v * Math.pow(10, %v)) / Math.pow(10, %v))`, args[0], args[1], args[1])
+}
+
+// scalar functions that are evaluated in painless rather than by an aggregation
+func isScriptFunc(funcExpr *sqlparser.FuncExpr) bool {
+	_, exist := scriptFuncs[strings.ToLower(funcExpr.Name.String())]
+	return exist
+}
+
+func (e *ESql) convertScriptFuncToScript(funcExpr *sqlparser.FuncExpr, aggMaps map[string]aggregation) (script string, err error) {
+	funcName := strings.ToLower(funcExpr.Name.String())
+	if funcExpr.Distinct {
+		err = errorf(ErrUnsupported, sqlparser.String(funcExpr), "esql: function %v w/ DISTINCT not supported", funcName)
+		return "", err
+	}
+	args, err := funcExprArgs(funcExpr)
+	if err != nil {
+		return "", err
+	}
+	fn := scriptFuncs[funcName]
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		err = errorf(ErrSyntax, sqlparser.String(funcExpr), "esql: wrong number of arguments for function %v", funcName)
+		return "", err
+	}
+	for i, arg := range args {
+		expected, actual := fn.argType(i), e.scriptTypeOf(arg)
+		if expected != typeAny && actual != typeAny && expected != actual {
+			err = errorf(ErrSyntax, sqlparser.String(arg), "esql: argument %v of function %v should be %v, got %v", i+1, funcName, expected, actual)
+			return "", err
+		}
+	}
+
+	if fn.translate != nil {
+		var argScripts, notNullSlice []string
+		for _, arg := range args {
+			val, notNull, err := e.convertToNullCheckedScript(arg, aggMaps)
+			if err != nil {
+				return "", err
+			}
+			argScripts = append(argScripts, val)
+			if notNull != "" {
+				notNullSlice = append(notNullSlice, notNull)
+			}
+		}
+		script = fn.translate(argScripts)
+		if len(notNullSlice) > 0 {
+			script = fmt.Sprintf(`(%v ? %v : null)`, strings.Join(notNullSlice, " && "), script)
+		}
+		return script, nil
+	}
+
+	switch funcName {
+	case "coalesce", "ifnull":
+		// the first not null argument
+		script = "null"
+		for i := len(args) - 1; i >= 0; i-- {
+			val, notNull, err := e.convertToNullCheckedScript(args[i], aggMaps)
+			if err != nil {
+				return "", err
+			}
+			if notNull == "" {
+				script = val
+			} else {
+				script = fmt.Sprintf(`(%v ? %v : %v)`, notNull, val, script)
+			}
+		}
+	case "nullif":
+		lhs, err := e.convertToNullableScript(args[0], aggMaps)
+		if err != nil {
+			return "", err
+		}
+		rhs, err := e.convertToNullableScript(args[1], aggMaps)
+		if err != nil {
+			return "", err
+		}
+		script = fmt.Sprintf(`(%v == %v ? null : %v)`, lhs, rhs, lhs)
+	}
+	return script, nil
+}
+
+func funcExprArgs(funcExpr *sqlparser.FuncExpr) (args []sqlparser.Expr, err error) {
+	for _, selectExpr := range funcExpr.Exprs {
+		aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr)
+		if !ok {
+			err = errorf(ErrSyntax, sqlparser.String(funcExpr), "esql: invalid argument of function %v", funcExpr.Name.String())
+			return nil, err
+		}
+		args = append(args, aliasedExpr.Expr)
+	}
+	return args, nil
+}
+
+// SUBSTRING(colName, pos[, len]), pos starts from 1
+func (e *ESql) convertSubstrExprToScript(substrExpr *sqlparser.SubstrExpr, aggMaps map[string]aggregation) (script string, err error) {
+	val, notNull, err := e.convertToNullCheckedScript(substrExpr.Name, aggMaps)
+	if err != nil {
+		return "", err
+	}
+	if substrExpr.From == nil {
+		err = errorf(ErrSyntax, sqlparser.String(substrExpr), "esql: SUBSTRING without position")
+		return "", err
+	}
+	for _, arg := range []sqlparser.Expr{substrExpr.From, substrExpr.To} {
+		if arg != nil && e.scriptTypeOf(arg) != typeAny && e.scriptTypeOf(arg) != typeNumber {
+			err = errorf(ErrSyntax, sqlparser.String(arg), "esql: position and length of SUBSTRING should be number")
+			return "", err
+		}
+	}
+	from, err := e.convertToScript(substrExpr.From, aggMaps)
+	if err != nil {
+		return "", err
+	}
+	// a position past the end gives an empty string rather than an exception, as does a negative length
+	start := fmt.Sprintf(`(int) Math.min(%v.length(), Math.max(0, %v - 1))`, val, from)
+	if substrExpr.To == nil {
+		script = fmt.Sprintf(`%v.substring(%v)`, val, start)
+	} else {
+		length, err := e.convertToScript(substrExpr.To, aggMaps)
+		if err != nil {
+			return "", err
+		}
+		end := fmt.Sprintf(`(int) Math.max(%v, Math.min(%v.length(), %v - 1 + %v))`, start, val, from, length)
+		script = fmt.Sprintf(`%v.substring(%v, %v)`, val, start, end)
+	}
+	return fmt.Sprintf(`(%v ? %v : null)`, notNull, script), nil
+}
+
+// scriptTypeOf infers the type of expr, typeAny if unknown
+func (e *ESql) scriptTypeOf(expr sqlparser.Expr) scriptType {
+	switch expr := expr.(type) {
+	case *sqlparser.SQLVal:
+		switch expr.Type {
+		case sqlparser.StrVal:
+			return typeString
+		case sqlparser.IntVal, sqlparser.FloatVal:
+			return typeNumber
+		}
+	case sqlparser.BoolVal:
+		return typeBool
+	case *sqlparser.ParenExpr:
+		return e.scriptTypeOf(expr.Expr)
+	case *sqlparser.UnaryExpr:
+		return typeNumber
+	case *sqlparser.BinaryExpr:
+		// + concatenates strings in painless
+		if expr.Operator == "+" && (e.scriptTypeOf(expr.Left) == typeString || e.scriptTypeOf(expr.Right) == typeString) {
+			return typeString
+		}
+		if expr.Operator == "+" && (e.scriptTypeOf(expr.Left) == typeAny || e.scriptTypeOf(expr.Right) == typeAny) {
+			return typeAny
+		}
+		return typeNumber
+	case *sqlparser.SubstrExpr:
+		return typeString
+	case *sqlparser.ConvertExpr:
+		if expr.Type == nil {
+			return typeAny
+		}
+		switch strings.ToLower(strings.Fields(expr.Type.Type + " ")[0]) {
+		case "signed", "unsigned", "decimal":
+			return typeNumber
+		case "char", "nchar":
+			return typeString
+		}
+	case *sqlparser.FuncExpr:
+		if isScriptFunc(expr) {
+			return scriptFuncs[strings.ToLower(expr.Name.String())].retType
+		}
+		// aggregation functions
+		return typeNumber
+	}
+	return typeAny
+}
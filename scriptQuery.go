@@ -2,36 +2,77 @@ package esql
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/xwb1989/sqlparser"
 )
 
-func (e *ESql) convertToScript(exprToConvert sqlparser.Expr, aggMaps map[string]string) (script string, err error) {
+func (e *ESql) convertToScript(exprToConvert sqlparser.Expr, aggMaps map[string]aggregation) (script string, err error) {
 	switch expr := exprToConvert.(type) {
 	case *sqlparser.ColName:
+		if e.docScript != nil {
+			script, err = e.convertToNullableScript(expr, aggMaps)
+			break
+		}
 		script, err = e.convertColName(expr)
+		if err != nil {
+			return "", err
+		}
+		script, err = e.scriptField(script)
 		script = fmt.Sprintf(`doc['%v'].value`, script)
 	case *sqlparser.SQLVal:
+		if lit, ok := convertLiteral(expr); ok && e.docScript != nil {
+			script = e.docScript.param(lit)
+			break
+		}
 		script, err = e.convertValExpr(expr, true)
 	case *sqlparser.BinaryExpr:
+		if isDateExpr(expr) {
+			script, err = e.convertToDateScript(expr, aggMaps)
+			break
+		}
 		script, err = e.convertBinaryExprToScript(expr, aggMaps)
 	case *sqlparser.ParenExpr:
 		script, err = e.convertToScript(expr.Expr, aggMaps)
 		script = fmt.Sprintf(`(%v)`, script)
 	case *sqlparser.UnaryExpr:
 		script, err = e.convertUnaryExprToScript(expr, aggMaps)
+	case *sqlparser.NullVal:
+		script = "null"
+	case sqlparser.BoolVal:
+		script = fmt.Sprintf(`%v`, bool(expr))
+	case *sqlparser.CaseExpr:
+		script, err = e.convertCaseExprToScript(expr, aggMaps)
+	case *sqlparser.ConvertExpr:
+		script, err = e.convertCastExprToScript(expr, aggMaps)
+	case *sqlparser.SubstrExpr:
+		script, err = e.convertSubstrExprToScript(expr, aggMaps)
+	case *sqlparser.IntervalExpr:
+		err = errorf(ErrUnsupported, sqlparser.String(expr), "esql: INTERVAL is only supported in date arithmetics")
 	case *sqlparser.FuncExpr:
-		tag, body, err := e.convertFuncExpr(*expr)
+		if isScriptFunc(expr) {
+			script, err = e.convertScriptFuncToScript(expr, aggMaps)
+			break
+		}
+		if isDateFunc(expr) {
+			script, err = e.convertDateFuncToScript(expr, aggMaps)
+			break
+		}
+		if tag, selected := selectedAggregation(expr, aggMaps); selected {
+			script = fmt.Sprintf(`params.%v`, tag)
+			break
+		}
+		tag, agg, err := e.convertFuncExpr(*expr)
 		if err != nil {
 			return "", err
 		}
 		script = fmt.Sprintf(`params.%v`, tag)
 		// here we suppose aggMaps is initialized
 		if _, exist := aggMaps[tag]; !exist {
-			aggMaps[tag] = body
+			aggMaps[tag] = agg
 		}
 	default:
-		err = fmt.Errorf("esql: invalid expression type for scripting")
+		err = errorf(ErrUnsupported, sqlparser.String(expr), "esql: invalid expression type for scripting")
 	}
 	if err != nil {
 		return "", err
@@ -39,17 +80,24 @@ func (e *ESql) convertToScript(exprToConvert sqlparser.Expr, aggMaps map[string]
 	return script, nil
 }
 
-func (e *ESql) convertUnaryExprToScript(expr sqlparser.Expr, aggMaps map[string]string) (script string, err error) {
+func (e *ESql) convertUnaryExprToScript(expr sqlparser.Expr, aggMaps map[string]aggregation) (script string, err error) {
 	unaryExpr, ok := expr.(*sqlparser.UnaryExpr)
 	if !ok {
-		err = fmt.Errorf("esql: invalid unary expression")
+		err = errorf(ErrSyntax, sqlparser.String(expr), "esql: invalid unary expression")
 		return "", err
 	}
 	op, ok := opUnaryExpr[unaryExpr.Operator]
 	if !ok {
-		err = fmt.Errorf("esql: not supported binary expression operator")
+		err = errorf(ErrUnsupported, sqlparser.String(unaryExpr), "esql: not supported binary expression operator")
 		return "", err
 	}
+	if e.docScript != nil {
+		val, notNull, err := e.convertToNullCheckedScript(unaryExpr.Expr, aggMaps)
+		if err != nil {
+			return "", err
+		}
+		return nullableScript(fmt.Sprintf(`%v%v`, op, val), notNull), nil
+	}
 	script, err = e.convertToScript(unaryExpr.Expr, aggMaps)
 	if err != nil {
 		return "", err
@@ -59,20 +107,27 @@ func (e *ESql) convertUnaryExprToScript(expr sqlparser.Expr, aggMaps map[string]
 	return script, nil
 }
 
-func (e *ESql) convertBinaryExprToScript(expr sqlparser.Expr, aggMaps map[string]string) (script string, err error) {
+func (e *ESql) convertBinaryExprToScript(expr sqlparser.Expr, aggMaps map[string]aggregation) (script string, err error) {
 	var lhsScript, rhsScript string
 	binExpr, ok := expr.(*sqlparser.BinaryExpr)
 	if !ok {
-		err = fmt.Errorf("esql: invalid binary expression")
+		err = errorf(ErrSyntax, sqlparser.String(expr), "esql: invalid binary expression")
 		return "", err
 	}
 	lhsExpr, rhsExpr := binExpr.Left, binExpr.Right
 	op, ok := opBinaryExpr[binExpr.Operator]
 	if !ok {
-		err = fmt.Errorf("esql: not supported binary expression operator")
+		err = errorf(ErrUnsupported, sqlparser.String(binExpr), "esql: not supported binary expression operator")
 		return "", err
 	}
 
+	if e.docScript != nil {
+		script, notNull, err := e.convertBinaryExprToNullCheckedScript(binExpr, op, aggMaps)
+		if err != nil {
+			return "", err
+		}
+		return nullableScript(script, notNull), nil
+	}
 	lhsScript, err = e.convertToScript(lhsExpr, aggMaps)
 	if err != nil {
 		return "", err
@@ -85,3 +140,299 @@ func (e *ESql) convertBinaryExprToScript(expr sqlparser.Expr, aggMaps map[string
 	script = fmt.Sprintf(`%v %v %v`, lhsScript, op, rhsScript)
 	return script, nil
 }
+
+// convertBinaryExprToNullCheckedScript returns lhs op rhs, which is null if either side is null
+func (e *ESql) convertBinaryExprToNullCheckedScript(binExpr *sqlparser.BinaryExpr, op string, aggMaps map[string]aggregation) (script string, notNull string, err error) {
+	lhsScript, lhsNotNull, err := e.convertToNullCheckedScript(binExpr.Left, aggMaps)
+	if err != nil {
+		return "", "", err
+	}
+	rhsScript, rhsNotNull, err := e.convertToNullCheckedScript(binExpr.Right, aggMaps)
+	if err != nil {
+		return "", "", err
+	}
+	script = fmt.Sprintf(`%v %v %v`, lhsScript, op, rhsScript)
+	return script, joinNotNull(lhsNotNull, rhsNotNull), nil
+}
+
+// convertComparisonToScript returns the painless comparison lhs op rhs, and in a script on doc values
+// a check that both sides are not null, since comparing null is unknown in sql and matches nothing
+func (e *ESql) convertComparisonToScript(lhsExpr, rhsExpr sqlparser.Expr, op string, aggMaps map[string]aggregation) (script string, notNull string, err error) {
+	var lhsScript, rhsScript string
+	isDate := isDateExpr(lhsExpr) || isDateExpr(rhsExpr)
+	lhsType, rhsType := e.scriptTypeOf(lhsExpr), e.scriptTypeOf(rhsExpr)
+	// dates are compared in millis, and so are date literals compared to them
+	if isDate && isDateLiteral(lhsExpr) {
+		lhsType = typeNumber
+	}
+	if isDate && isDateLiteral(rhsExpr) {
+		rhsType = typeNumber
+	}
+	// painless can not compare a string to a number
+	if lhsType != typeAny && rhsType != typeAny && lhsType != rhsType {
+		err = errorf(ErrSyntax, sqlparser.String(lhsExpr), "esql: can not compare %v %v to %v %v", lhsType, sqlparser.String(lhsExpr), rhsType, sqlparser.String(rhsExpr))
+		return "", "", err
+	}
+	switch {
+	case isDate:
+		lhsScript, err = e.convertToDateScript(lhsExpr, aggMaps)
+		if err != nil {
+			return "", "", err
+		}
+		rhsScript, err = e.convertToDateScript(rhsExpr, aggMaps)
+		if err != nil {
+			return "", "", err
+		}
+		if e.docScript != nil {
+			notNull, err = e.columnsNotNull(lhsExpr, rhsExpr)
+		}
+	case e.docScript != nil:
+		var lhsNotNull, rhsNotNull string
+		lhsScript, lhsNotNull, err = e.convertToNullCheckedScript(lhsExpr, aggMaps)
+		if err != nil {
+			return "", "", err
+		}
+		rhsScript, rhsNotNull, err = e.convertToNullCheckedScript(rhsExpr, aggMaps)
+		notNull = joinNotNull(lhsNotNull, rhsNotNull)
+	default:
+		lhsScript, err = e.convertToScript(lhsExpr, aggMaps)
+		if err != nil {
+			return "", "", err
+		}
+		rhsScript, err = e.convertToScript(rhsExpr, aggMaps)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	script = fmt.Sprintf(`%v %v %v`, lhsScript, op, rhsScript)
+	return script, notNull, nil
+}
+
+// convertToNullCheckedScript returns the script of expr, and a script checking that expr is not null.
+// a missing column is null instead of an exception. notNull is empty if expr is never null
+func (e *ESql) convertToNullCheckedScript(expr sqlparser.Expr, aggMaps map[string]aggregation) (script string, notNull string, err error) {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		colNameStr, err := e.convertColName(expr)
+		if err != nil {
+			return "", "", err
+		}
+		colNameStr, err = e.scriptField(colNameStr)
+		if err != nil {
+			return "", "", err
+		}
+		script = fmt.Sprintf(`doc['%v'].value`, colNameStr)
+		notNull = fmt.Sprintf(`doc['%v'].size() != 0`, colNameStr)
+		return script, notNull, nil
+	case *sqlparser.SQLVal, sqlparser.BoolVal:
+		script, err = e.convertToScript(expr, aggMaps)
+		return script, "", err
+	}
+	if e.docScript != nil {
+		switch expr := expr.(type) {
+		case *sqlparser.ParenExpr:
+			script, notNull, err = e.convertToNullCheckedScript(expr.Expr, aggMaps)
+			return fmt.Sprintf(`(%v)`, script), notNull, err
+		case *sqlparser.BinaryExpr:
+			if op, ok := opBinaryExpr[expr.Operator]; ok && !isDateExpr(expr) {
+				return e.convertBinaryExprToNullCheckedScript(expr, op, aggMaps)
+			}
+		case *sqlparser.UnaryExpr:
+			if op, ok := opUnaryExpr[expr.Operator]; ok {
+				script, notNull, err = e.convertToNullCheckedScript(expr.Expr, aggMaps)
+				return fmt.Sprintf(`%v%v`, op, script), notNull, err
+			}
+		}
+		if funcExpr, ok := expr.(*sqlparser.FuncExpr); isDateExpr(expr) || ok && isDateFunc(funcExpr) {
+			// dates are null if any of their columns is null, the script throws otherwise
+			script, err = e.convertToScript(expr, aggMaps)
+			if err != nil {
+				return "", "", err
+			}
+			notNull, err = e.columnsNotNull(expr)
+			return script, notNull, err
+		}
+	}
+	script, err = e.convertToScript(expr, aggMaps)
+	if err != nil {
+		return "", "", err
+	}
+	return script, fmt.Sprintf(`(%v) != null`, script), nil
+}
+
+// nullableScript returns script if notNull holds, otherwise null
+func nullableScript(script string, notNull string) string {
+	if notNull == "" {
+		return script
+	}
+	return fmt.Sprintf(`(%v ? %v : null)`, notNull, script)
+}
+
+// joinNotNull combines not null checks by &&, a column checked on both sides is checked once
+func joinNotNull(notNulls ...string) string {
+	var checks []string
+	seen := make(map[string]bool)
+	for _, notNull := range notNulls {
+		for _, check := range splitNotNull(notNull) {
+			if !seen[check] {
+				seen[check] = true
+				checks = append(checks, check)
+			}
+		}
+	}
+	return strings.Join(checks, " && ")
+}
+
+// splitNotNull splits a not null check at the && outside of parentheses
+func splitNotNull(notNull string) (checks []string) {
+	depth, start := 0, 0
+	for i := 0; i < len(notNull); i++ {
+		switch notNull[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '&':
+			if depth == 0 && strings.HasPrefix(notNull[i:], "&& ") && i > start {
+				checks = append(checks, strings.TrimSpace(notNull[start:i]))
+				start = i + 3
+			}
+		}
+	}
+	if rest := strings.TrimSpace(notNull[start:]); rest != "" {
+		checks = append(checks, rest)
+	}
+	return checks
+}
+
+// columnsNotNull returns a script checking that all columns in exprs exist
+func (e *ESql) columnsNotNull(exprs ...sqlparser.Expr) (notNull string, err error) {
+	var checks []string
+	seen := make(map[string]bool)
+	for _, expr := range exprs {
+		err = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			colName, ok := node.(*sqlparser.ColName)
+			if !ok {
+				return true, nil
+			}
+			colNameStr, err := e.convertColName(colName)
+			if err != nil {
+				return false, err
+			}
+			if !seen[colNameStr] {
+				seen[colNameStr] = true
+				checks = append(checks, fmt.Sprintf(`doc['%v'].size() != 0`, colNameStr))
+			}
+			return false, nil
+		}, expr)
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(checks, " && "), nil
+}
+
+// docScript collects the literals of a painless script on doc values as params, so that es compiles
+// the script once for all queries of the same shape
+type docScript struct {
+	params []string
+}
+
+// param adds lit to the params and returns the reference to it in the script
+func (d *docScript) param(lit literal) string {
+	name := fmt.Sprintf(`p%v`, len(d.params))
+	d.params = append(d.params, fmt.Sprintf(`"%v": %v`, name, lit.json()))
+	return `params.` + name
+}
+
+// convertToDocScript builds a script on doc values by build, and returns its source and the params
+// of the literals in it. params is empty in legacy mode, where literals are inlined into the source
+func (e *ESql) convertToDocScript(build func() (string, error)) (source string, params string, err error) {
+	if e.legacyScript {
+		source, err = build()
+		return source, "", err
+	}
+	outer := e.docScript
+	e.docScript = &docScript{}
+	defer func() { e.docScript = outer }()
+	source, err = build()
+	if err != nil {
+		return "", "", err
+	}
+	if len(e.docScript.params) > 0 {
+		params = fmt.Sprintf(`, "params": {%v}`, strings.Join(e.docScript.params, ", "))
+	}
+	return source, params, nil
+}
+
+// convertToNullableScript returns the script of expr, in which a missing column is null
+func (e *ESql) convertToNullableScript(expr sqlparser.Expr, aggMaps map[string]aggregation) (script string, err error) {
+	script, notNull, err := e.convertToNullCheckedScript(expr, aggMaps)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := expr.(*sqlparser.ColName); ok {
+		script = fmt.Sprintf(`(%v ? %v : null)`, notNull, script)
+	}
+	return script, nil
+}
+
+// CASE [base] WHEN cond1 THEN val1 ... ELSE valElse END -> (cond1 ? val1 : (... : valElse))
+func (e *ESql) convertCaseExprToScript(caseExpr *sqlparser.CaseExpr, aggMaps map[string]aggregation) (script string, err error) {
+	if len(caseExpr.Whens) == 0 {
+		err = errorf(ErrSyntax, sqlparser.String(caseExpr), "esql: CASE without WHEN")
+		return "", err
+	}
+	script = "null"
+	if caseExpr.Else != nil {
+		script, err = e.convertToNullableScript(caseExpr.Else, aggMaps)
+		if err != nil {
+			return "", err
+		}
+	}
+	for i := len(caseExpr.Whens) - 1; i >= 0; i-- {
+		when := caseExpr.Whens[i]
+		cond := when.Cond
+		if caseExpr.Expr != nil {
+			cond = &sqlparser.ComparisonExpr{Operator: sqlparser.EqualStr, Left: caseExpr.Expr, Right: when.Cond}
+		}
+		condScript, err := e.convertHavingExpr(cond, aggMaps)
+		if err != nil {
+			return "", err
+		}
+		valScript, err := e.convertToNullableScript(when.Val, aggMaps)
+		if err != nil {
+			return "", err
+		}
+		script = fmt.Sprintf(`(%v ? %v : %v)`, condScript, valScript, script)
+	}
+	return script, nil
+}
+
+// CAST(expr AS type), null stays null
+func (e *ESql) convertCastExprToScript(convertExpr *sqlparser.ConvertExpr, aggMaps map[string]aggregation) (script string, err error) {
+	if convertExpr.Type == nil {
+		err = errorf(ErrSyntax, sqlparser.String(convertExpr), "esql: CAST without type")
+		return "", err
+	}
+	val, notNull, err := e.convertToNullCheckedScript(convertExpr.Expr, aggMaps)
+	if err != nil {
+		return "", err
+	}
+	castType := strings.ToLower(strings.Join(strings.Fields(convertExpr.Type.Type), " "))
+	switch castType {
+	case "signed", "signed integer", "unsigned", "unsigned integer":
+		script = fmt.Sprintf(`(%v instanceof Number ? ((Number) %v).longValue() : Long.parseLong(String.valueOf(%v)))`, val, val, val)
+	case "decimal":
+		script = fmt.Sprintf(`(%v instanceof Number ? ((Number) %v).doubleValue() : Double.parseDouble(String.valueOf(%v)))`, val, val, val)
+	case "char", "nchar":
+		script = fmt.Sprintf(`String.valueOf(%v)`, val)
+	default:
+		err = errorf(ErrUnsupported, sqlparser.String(convertExpr), "esql: CAST to %v not supported", castType)
+		return "", err
+	}
+	if notNull != "" {
+		script = fmt.Sprintf(`(%v ? %v : null)`, notNull, script)
+	}
+	return script, nil
+}
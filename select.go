@@ -9,7 +9,7 @@ import (
 
 func (e *ESql) convertSelect(sel sqlparser.Select, domainID string, pagination ...interface{}) (dsl string, sortField []string, err error) {
 	if sel.Distinct != "" {
-		err := fmt.Errorf(`esql: SELECT DISTINCT not supported. use GROUP BY instead`)
+		err := errorf(ErrUnsupported, strings.TrimSpace(sel.Distinct), `esql: SELECT DISTINCT not supported. use GROUP BY instead`)
 		return "", nil, err
 	}
 
@@ -17,23 +17,43 @@ func (e *ESql) convertSelect(sel sqlparser.Select, domainID string, pagination .
 	// a map that contains the main components of a query
 	dslMap := make(map[string]interface{})
 
+	// handle FROM keyword, the tables are indices, aliases or patterns to search together
+	if len(sel.From) == 0 {
+		err = errorf(ErrSyntax, "", "esql: invalid from expressino: no from expression specified")
+		return "", nil, err
+	}
+	if err = e.setIndex(sel); err != nil {
+		return "", nil, err
+	}
+
 	// handle WHERE keyword
+	e.innerHitsOf = nil
+	var where sqlparser.Expr
 	if sel.Where != nil {
-		dslQuery, err := e.convertWhereExpr(sel.Where.Expr, rootParent)
+		var optimized bool
+		if where, optimized = e.optimize(sel.Where.Expr); optimized {
+			e.explanation.addOptimizedWhere(where)
+		}
+	}
+	if e.request != nil {
+		if where != nil {
+			where = e.liftRequestParams(where)
+		}
+		// w/o _index in WHERE, search the time indices the time range of WHERE can be in
+		if e.request.Index == nil {
+			e.request.Index, e.request.IgnoreUnavailable = e.pruneTimeIndices(where)
+		}
+	}
+	if where != nil {
+		where, err = e.nestWhere(where, "")
 		if err != nil {
 			return "", nil, err
 		}
-		dslMap["query"] = dslQuery
-	}
-
-	// handle FROM keyword, currently only support 1 target table
-	if len(sel.From) != 1 {
-		if len(sel.From) == 0 {
-			err = fmt.Errorf("esql: invalid from expressino: no from expression specified")
-		} else {
-			err = fmt.Errorf("esql: join not supported")
+		dslQuery, err := e.convertWhereExpr(where, rootParent)
+		if err != nil {
+			return "", nil, err
 		}
-		return "", nil, err
+		dslMap["query"] = dslQuery
 	}
 
 	// handle SELECT body, including aggregations and GROUP BY, SELECT <agg function>, ORDER BY <agg function>, HAVING
@@ -64,12 +84,11 @@ func (e *ESql) convertSelect(sel sqlparser.Select, domainID string, pagination .
 		// handle pagination
 		var searchAfterSlice []string
 		for _, v := range pagination {
-			switch v.(type) {
-			case int:
-				searchAfterSlice = append(searchAfterSlice, fmt.Sprintf(`%v`, v))
-			default:
-				searchAfterSlice = append(searchAfterSlice, fmt.Sprintf(`"%v"`, v))
+			searchAfterStr, err := searchAfterValue(v)
+			if err != nil {
+				return "", nil, err
 			}
+			searchAfterSlice = append(searchAfterSlice, searchAfterStr)
 		}
 		if len(searchAfterSlice) > 0 {
 			searchAfterStr := strings.Join(searchAfterSlice, ",")
@@ -84,16 +103,27 @@ func (e *ESql) convertSelect(sel sqlparser.Select, domainID string, pagination .
 		var orderBySlice []string
 		for _, orderExpr := range sel.OrderBy {
 			var colNameStr string
+			if isGeoDistanceFunc(orderExpr.Expr) {
+				orderByStr, err := e.convertGeoDistanceSort(orderExpr)
+				if err != nil {
+					return "", nil, err
+				}
+				orderBySlice = append(orderBySlice, orderByStr)
+				sortField = append(sortField, "_geo_distance")
+				continue
+			}
 			if colName, ok := orderExpr.Expr.(*sqlparser.ColName); ok {
 				colNameStr, err = e.convertColName(colName)
 				if err != nil {
 					return "", nil, err
 				}
 			} else {
-				err := fmt.Errorf(`esql: mix order by aggregations and column names`)
+				err := errorf(ErrUnsupported, sqlparser.String(orderExpr), `esql: mix order by aggregations and column names`)
 				return "", nil, err
 			}
 			colNameStr = strings.Trim(colNameStr, "`")
+			// text fields are sorted by the keyword sub field
+			colNameStr, _ = e.keywordField(colNameStr)
 			orderByStr := fmt.Sprintf(`{"%v": "%v"}`, colNameStr, orderExpr.Direction)
 			orderBySlice = append(orderBySlice, orderByStr)
 			sortField = append(sortField, colNameStr)
@@ -112,16 +142,64 @@ func (e *ESql) convertSelect(sel sqlparser.Select, domainID string, pagination .
 	return dsl, sortField, nil
 }
 
+// setIndex resolves the tables in FROM to the indices being queried, which are used to look up field
+// mappings. a table is a comma separated list of indices, aliases or patterns like logs-*, or a name
+// mapped to indices by the index mapping
+func (e *ESql) setIndex(sel sqlparser.Select) error {
+	e.indices, e.tableAliases = nil, nil
+	indexSet := make(map[string]int)
+	for _, tableExpr := range sel.From {
+		aliasedTableExpr, ok := tableExpr.(*sqlparser.AliasedTableExpr)
+		if !ok {
+			return errorf(ErrUnsupported, sqlparser.String(tableExpr), "esql: join not supported")
+		}
+		tableName, ok := aliasedTableExpr.Expr.(sqlparser.TableName)
+		if !ok {
+			return errorf(ErrUnsupported, sqlparser.String(tableExpr), "esql: subquery in FROM not supported")
+		}
+		if !aliasedTableExpr.As.IsEmpty() {
+			if e.tableAliases == nil {
+				e.tableAliases = make(map[string]int)
+			}
+			e.tableAliases[aliasedTableExpr.As.String()] = 1
+		}
+		table := strings.Replace(sqlparser.String(tableName), "`", "", -1)
+		for _, name := range strings.Split(table, ",") {
+			name = strings.TrimSpace(name)
+			indices := []string{name}
+			if e.indexMapping != nil {
+				mapped, err := e.indexMapping(name)
+				if err != nil {
+					return wrapError(ErrMacro, name, err)
+				}
+				if len(mapped) > 0 {
+					indices = mapped
+				}
+			}
+			for _, index := range indices {
+				if _, exist := indexSet[index]; !exist && index != "" {
+					indexSet[index] = 1
+					e.indices = append(e.indices, index)
+				}
+			}
+		}
+	}
+	e.index = strings.Join(e.indices, ",")
+	return nil
+}
+
 func (e *ESql) convertWhereExpr(expr sqlparser.Expr, parent sqlparser.Expr) (string, error) {
 	var err error
 	if expr == nil {
-		err = fmt.Errorf("esql: invalid where expression, where expression should not be nil")
+		err = errorf(ErrSyntax, "", "esql: invalid where expression, where expression should not be nil")
 		return "", err
 	}
 
 	switch expr.(type) {
 	case *sqlparser.ComparisonExpr:
-		return e.convertComparisionExpr(expr, parent, false)
+		dsl, err := e.convertComparisionExpr(expr, parent, false)
+		e.explanation.addPredicate(expr, false, dsl)
+		return dsl, err
 	case *sqlparser.AndExpr:
 		return e.convertAndExpr(expr, parent)
 	case *sqlparser.OrExpr:
@@ -131,11 +209,41 @@ func (e *ESql) convertWhereExpr(expr sqlparser.Expr, parent sqlparser.Expr) (str
 	case *sqlparser.NotExpr:
 		return e.convertNotExpr(expr, parent)
 	case *sqlparser.RangeCond:
-		return e.convertBetweenExpr(expr, parent, true, true, false)
+		fromInclusive, toInclusive := rangeInclusive(expr.(*sqlparser.RangeCond).Operator)
+		dsl, err := e.convertBetweenExpr(expr, parent, fromInclusive, toInclusive, false)
+		e.explanation.addPredicate(expr, false, dsl)
+		return dsl, err
 	case *sqlparser.IsExpr:
-		return e.convertIsExpr(expr, parent, false)
+		dsl, err := e.convertIsExpr(expr, parent, false)
+		e.explanation.addPredicate(expr, false, dsl)
+		return dsl, err
+	case *sqlparser.FuncExpr:
+		if isNestedFunc(expr) {
+			return e.convertNestedExpr(expr)
+		}
+		if isJoinFunc(expr) {
+			return e.convertJoinExpr(expr)
+		}
+		if isGeoFunc(expr) {
+			return e.convertGeoExpr(expr)
+		}
+		if isCIDRMatchFunc(expr) {
+			comparisonExpr, err := cidrMatchComparison(expr.(*sqlparser.FuncExpr))
+			if err != nil {
+				return "", err
+			}
+			return e.convertComparisionExpr(comparisonExpr, parent, false)
+		}
+		err = errorf(ErrUnsupported, sqlparser.String(expr), `esql: function %v not supported in WHERE clause`, expr.(*sqlparser.FuncExpr).Name.String())
+		return "", err
+	case sqlparser.BoolVal:
+		// WHERE FALSE, or a contradiction found by the optimizer
+		if expr.(sqlparser.BoolVal) {
+			return `{"match_all": {}}`, nil
+		}
+		return `{"bool": {"must_not": {"match_all": {}}}}`, nil
 	default:
-		err = fmt.Errorf(`esql: %T expression not supported in WHERE clause`, expr)
+		err = errorf(ErrUnsupported, sqlparser.String(expr), `esql: %T expression not supported in WHERE clause`, expr)
 		return "", err
 	}
 }
@@ -144,7 +252,7 @@ func (e *ESql) convertBetweenExpr(expr sqlparser.Expr, parent sqlparser.Expr, fr
 	rangeCond := expr.(*sqlparser.RangeCond)
 	lhs, ok := rangeCond.Left.(*sqlparser.ColName)
 	if !ok {
-		err := fmt.Errorf("esql: invalid range column name")
+		err := errorf(ErrSyntax, sqlparser.String(rangeCond), "esql: invalid range column name")
 		return "", err
 	}
 	lhsStr, err := e.convertColName(lhs)
@@ -152,12 +260,46 @@ func (e *ESql) convertBetweenExpr(expr sqlparser.Expr, parent sqlparser.Expr, fr
 		return "", err
 	}
 
-	fromStr := strings.Trim(sqlparser.String(rangeCond.From), `'`)
-	toStr := strings.Trim(sqlparser.String(rangeCond.To), `'`)
-	op := rangeCond.Operator
-	if not {
-		op = oppositeOperator[op]
+	// date math and date literals are parsed in the time zone
+	var fromStr, toStr string
+	var dateFormats []string
+	isDate := false
+	for _, bound := range []struct {
+		expr sqlparser.Expr
+		str  *string
+	}{{rangeCond.From, &fromStr}, {rangeCond.To, &toStr}} {
+		if dateMath, ok := e.convertDateMath(bound.expr); ok {
+			*bound.str = jsonString(dateMath)
+			isDate = true
+			continue
+		}
+		lit, ok := convertLiteral(bound.expr)
+		if !ok || lit.typ == literalNull {
+			err := errorf(ErrUnsupported, sqlparser.String(rangeCond), "esql: BETWEEN only supports literals and date math")
+			return "", err
+		}
+		lit, err = e.processLiteral(lhsStr, lit)
+		if err != nil {
+			return "", err
+		}
+		lit, err = e.coerceLiteral(lhsStr, lit)
+		if err != nil {
+			return "", err
+		}
+		*bound.str = lit.json()
+		if format, ok := e.dateFieldFormat(lhsStr, lit); ok {
+			if format != "" && (len(dateFormats) == 0 || dateFormats[0] != format) {
+				dateFormats = append(dateFormats, format)
+			}
+			isDate = true
+		}
 	}
+	var dateParams string
+	if isDate {
+		dateParams = e.dateRangeParams(strings.Join(dateFormats, "||"))
+	}
+	// a range merged by the optimizer has no opposite operator
+	negate := (rangeCond.Operator == sqlparser.NotBetweenStr) != not
 
 	gt := "gte"
 	lt := "lte"
@@ -168,9 +310,9 @@ func (e *ESql) convertBetweenExpr(expr sqlparser.Expr, parent sqlparser.Expr, fr
 		lt = "lt"
 	}
 
-	dsl := fmt.Sprintf(`{"range": {"%v": {"%v": "%v", "%v": "%v"}}}`, lhsStr, gt, fromStr, lt, toStr)
-	if op == sqlparser.NotBetweenStr {
-		dsl = fmt.Sprintf(`{"bool": {"must_not": [%v]}}`, dsl)
+	dsl := fmt.Sprintf(`{"range": {"%v": {"%v": %v, "%v": %v%v}}}`, lhsStr, gt, fromStr, lt, toStr, dateParams)
+	if negate {
+		dsl = e.mustNot(lhsStr, fmt.Sprintf(`[%v]`, dsl))
 	}
 	return dsl, nil
 }
@@ -207,13 +349,39 @@ func (e *ESql) convertNotExpr(expr sqlparser.Expr, parent sqlparser.Expr) (strin
 		var expr2 sqlparser.Expr = &sqlparser.NotExpr{Expr: exprBody}
 		return e.convertNotExpr(expr2, parent)
 	case *sqlparser.ComparisonExpr:
-		return e.convertComparisionExpr(exprInside, parent, true)
+		dsl, err := e.convertComparisionExpr(exprInside, parent, true)
+		e.explanation.addPredicate(exprInside, true, dsl)
+		return dsl, err
 	case *sqlparser.IsExpr:
-		return e.convertIsExpr(exprInside, parent, true)
+		dsl, err := e.convertIsExpr(exprInside, parent, true)
+		e.explanation.addPredicate(exprInside, true, dsl)
+		return dsl, err
 	case *sqlparser.RangeCond:
-		return e.convertBetweenExpr(exprInside, parent, true, true, true)
+		fromInclusive, toInclusive := rangeInclusive(exprInside.(*sqlparser.RangeCond).Operator)
+		dsl, err := e.convertBetweenExpr(exprInside, parent, fromInclusive, toInclusive, true)
+		e.explanation.addPredicate(exprInside, true, dsl)
+		return dsl, err
+	case sqlparser.BoolVal:
+		return e.convertWhereExpr(!exprInside.(sqlparser.BoolVal), parent)
+	case *sqlparser.FuncExpr:
+		if isCIDRMatchFunc(exprInside) {
+			comparisonExpr, err := cidrMatchComparison(exprInside.(*sqlparser.FuncExpr))
+			if err != nil {
+				return "", err
+			}
+			return e.convertComparisionExpr(comparisonExpr, parent, true)
+		}
+		// no element of the nested object, or no child or parent matches
+		negated := e.negated
+		e.negated = true
+		dsl, err := e.convertWhereExpr(exprInside, parent)
+		e.negated = negated
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(`{"bool": {"must_not": %v}}`, dsl), nil
 	default:
-		err := fmt.Errorf("esql: %T expression not supported", exprInside)
+		err := errorf(ErrUnsupported, sqlparser.String(exprInside), "esql: %T expression not supported", exprInside)
 		return "", err
 	}
 }
@@ -276,7 +444,7 @@ func (e *ESql) convertIsExpr(expr sqlparser.Expr, parent sqlparser.Expr, not boo
 	isExpr := expr.(*sqlparser.IsExpr)
 	lhs, ok := isExpr.Expr.(*sqlparser.ColName)
 	if !ok {
-		return "", fmt.Errorf("esql: is expression only support colname missing check")
+		return "", errorf(ErrUnsupported, sqlparser.String(isExpr), "esql: is expression only support colname missing check")
 	}
 	lhsStr, err := e.convertColName(lhs)
 	if err != nil {
@@ -287,7 +455,7 @@ func (e *ESql) convertIsExpr(expr sqlparser.Expr, parent sqlparser.Expr, not boo
 	op := isExpr.Operator
 	if not {
 		if _, exist := oppositeOperator[op]; !exist {
-			err := fmt.Errorf("esql: is expression only support is null and is not null")
+			err := errorf(ErrUnsupported, sqlparser.String(isExpr), "esql: is expression only support is null and is not null")
 			return "", err
 		}
 		op = oppositeOperator[op]
@@ -297,8 +465,17 @@ func (e *ESql) convertIsExpr(expr sqlparser.Expr, parent sqlparser.Expr, not boo
 		dsl = fmt.Sprintf(`{"bool": {"must_not": {"exists": {"field": "%v"}}}}`, lhsStr)
 	case sqlparser.IsNotNullStr:
 		dsl = fmt.Sprintf(`{"exists": {"field": "%v"}}`, lhsStr)
+	case sqlparser.IsTrueStr:
+		dsl = fmt.Sprintf(`{"term": {"%v": true}}`, lhsStr)
+	case sqlparser.IsFalseStr:
+		dsl = fmt.Sprintf(`{"term": {"%v": false}}`, lhsStr)
+	// NULL is neither true nor false
+	case sqlparser.IsNotTrueStr:
+		dsl = fmt.Sprintf(`{"bool": {"must_not": {"term": {"%v": true}}}}`, lhsStr)
+	case sqlparser.IsNotFalseStr:
+		dsl = fmt.Sprintf(`{"bool": {"must_not": {"term": {"%v": false}}}}`, lhsStr)
 	default:
-		return "", fmt.Errorf("esql: is expression only support is null and is not null")
+		return "", errorf(ErrUnsupported, sqlparser.String(isExpr), "esql: is expression only support is null, is not null, is true and is false")
 	}
 	return dsl, nil
 }
@@ -312,46 +489,71 @@ func (e *ESql) convertComparisionExpr(expr sqlparser.Expr, parent sqlparser.Expr
 	var lhsStr, rhsStr, dsl string
 	// get operator
 	op := comparisonExpr.Operator
+	caseInsensitive := false
+	if op == sqlparser.LikeStr || op == sqlparser.NotLikeStr {
+		rhsExpr, caseInsensitive = unwrapILike(rhsExpr)
+	}
 	if not {
 		if _, exist := oppositeOperator[op]; !exist {
-			err := fmt.Errorf(`esql: %s operator not supported in comparison clause`, comparisonExpr.Operator)
+			err := errorf(ErrUnsupported, sqlparser.String(comparisonExpr), `esql: %s operator not supported in comparison clause`, comparisonExpr.Operator)
 			return "", err
 		}
 		op = oppositeOperator[op]
 	}
 
-	if _, ok := lhsExpr.(*sqlparser.ColName); !ok {
+	if isGeoDistanceFunc(lhsExpr) {
+		return e.convertGeoDistanceExpr(comparisonExpr, op)
+	}
+	if lhs, ok := lhsExpr.(*sqlparser.ColName); ok {
+		if dateMath, ok := e.convertDateMath(rhsExpr); ok {
+			return e.convertDateMathComparison(lhs, op, dateMath)
+		}
+	} else {
 		scriptQuery = true
 	}
-	switch rhsExpr.(type) {
-	case *sqlparser.SQLVal, sqlparser.ValTuple:
-		rhsStr, err = e.convertValExpr(rhsExpr, false)
+	// rhs is a literal, or a tuple of literals for IN
+	var rhsLit literal
+	var rhsTuple []literal
+	switch rhs := rhsExpr.(type) {
+	case sqlparser.ValTuple:
+		rhs, err = e.expandCIDR(lhsExpr, rhs)
 		if err != nil {
 			return "", err
 		}
-		rhsStr, err = e.valueProcess(lhsStr, rhsStr)
-		if err != nil {
-			return "", err
+		for _, valExpr := range rhs {
+			lit, ok := convertLiteral(valExpr)
+			if !ok {
+				err = errorf(ErrUnsupported, sqlparser.String(rhs), `esql: IN only supports a list of literals`)
+				return "", err
+			}
+			rhsTuple = append(rhsTuple, lit)
 		}
 	default:
-		scriptQuery = true
+		var ok bool
+		if rhsLit, ok = convertLiteral(rhsExpr); !ok {
+			scriptQuery = true
+		}
 	}
 
 	// use painless scripting query here
 	if scriptQuery {
-		aggMapsDummy := make(map[string]string)
-		lhsStr, err = e.convertToScript(lhsExpr, aggMapsDummy)
-		rhsStr, err = e.convertToScript(rhsExpr, aggMapsDummy)
-		if err != nil {
+		painlessOp, ok := op2PainlessOp[op]
+		if !ok {
+			err = errorf(ErrUnsupported, sqlparser.String(comparisonExpr), "esql: not supported painless operator")
 			return "", err
 		}
-		op, ok := op2PainlessOp[op]
-		if !ok {
-			err = fmt.Errorf("esql: not supported painless operator")
+		aggMapsDummy := make(map[string]aggregation)
+		source, params, err := e.convertToDocScript(func() (string, error) {
+			script, notNull, err := e.convertComparisonToScript(lhsExpr, rhsExpr, painlessOp, aggMapsDummy)
+			if err != nil || notNull == "" {
+				return script, err
+			}
+			return fmt.Sprintf(`%v && %v`, notNull, script), nil
+		})
+		if err != nil {
 			return "", err
 		}
-		script := fmt.Sprintf(`%v %v %v`, lhsStr, op, rhsStr)
-		dsl = fmt.Sprintf(`{"bool": {"filter": {"script": {"script": {"source": "%v"}}}}}`, script)
+		dsl = fmt.Sprintf(`{"bool": {"filter": {"script": {"script": {"source": "%v"%v}}}}}`, source, params)
 		return dsl, nil
 	}
 
@@ -360,50 +562,174 @@ func (e *ESql) convertComparisionExpr(expr sqlparser.Expr, parent sqlparser.Expr
 	if err != nil {
 		return "", err
 	}
+	var rhsLits []literal
+	if rhsTuple != nil {
+		// NULL never equals to anything, it is dropped from the list
+		var valSlice []string
+		for _, lit := range rhsTuple {
+			if lit.typ == literalNull {
+				// colName NOT IN (..., NULL) is never true in sql
+				if op == "not in" {
+					return `{"bool": {"must_not": {"match_all": {}}}}`, nil
+				}
+				continue
+			}
+			lit, err = e.processLiteral(lhsStr, lit)
+			if err != nil {
+				return "", err
+			}
+			lit, err = e.coerceLiteral(lhsStr, lit)
+			if err != nil {
+				return "", err
+			}
+			valSlice = append(valSlice, lit.json())
+			rhsLits = append(rhsLits, lit)
+		}
+		rhsStr = strings.Join(valSlice, ", ")
+	} else {
+		rhsLit, err = e.processLiteral(lhsStr, rhsLit)
+		if err != nil {
+			return "", err
+		}
+		if rhsLit.typ == literalNull {
+			err = errorf(ErrSyntax, sqlparser.String(comparisonExpr), `esql: comparison with NULL is never true, use IS NULL or IS NOT NULL`)
+			return "", err
+		}
+		rhsLit, err = e.coerceLiteral(lhsStr, rhsLit)
+		if err != nil {
+			return "", err
+		}
+		rhsStr = rhsLit.json()
+		rhsLits = []literal{rhsLit}
+	}
+
+	// documents are looked up by id rather than by a field
+	if lhsStr == "_id" {
+		if dsl, ok := e.convertIDsExpr(op, rhsLits); ok {
+			return dsl, nil
+		}
+	}
+
+	// a date is matched by a range query, which parses the literal in the time zone as a range query does
+	switch op {
+	case "=", "<>", "!=", "in", "not in":
+		if dsl, ok := e.convertDateEquality(lhsStr, op, rhsLits); ok {
+			return dsl, nil
+		}
+	}
+
+	// exact match goes to the keyword sub field of a text field, or falls back to match query
+	exactStr, exact := e.keywordField(lhsStr)
+	if !exact {
+		switch op {
+		case "=", "<>", "!=", "in", "not in":
+			return e.convertMatchExpr(lhsStr, op, rhsLit, rhsTuple)
+		}
+	}
+
+	// date literals in range queries are parsed in the time zone
+	var dateParams string
+	if format, ok := e.dateFieldFormat(lhsStr, rhsLit); ok {
+		dateParams = e.dateRangeParams(format)
+	}
 
 	// generate dsl according to operator
 	switch op {
 	case "=":
-		dsl = fmt.Sprintf(`{"term": {"%v": "%v"}}`, lhsStr, rhsStr)
+		dsl = fmt.Sprintf(`{"term": {"%v": %v}}`, exactStr, rhsStr)
 	case "<":
-		dsl = fmt.Sprintf(`{"range": {"%v": {"lt": "%v"}}}`, lhsStr, rhsStr)
+		dsl = fmt.Sprintf(`{"range": {"%v": {"lt": %v%v}}}`, lhsStr, rhsStr, dateParams)
 	case "<=":
-		dsl = fmt.Sprintf(`{"range": {"%v": {"lte": "%v"}}}`, lhsStr, rhsStr)
+		dsl = fmt.Sprintf(`{"range": {"%v": {"lte": %v%v}}}`, lhsStr, rhsStr, dateParams)
 	case ">":
-		dsl = fmt.Sprintf(`{"range": {"%v": {"gt": "%v"}}}`, lhsStr, rhsStr)
+		dsl = fmt.Sprintf(`{"range": {"%v": {"gt": %v%v}}}`, lhsStr, rhsStr, dateParams)
 	case ">=":
-		dsl = fmt.Sprintf(`{"range": {"%v": {"gte": "%v"}}}`, lhsStr, rhsStr)
+		dsl = fmt.Sprintf(`{"range": {"%v": {"gte": %v%v}}}`, lhsStr, rhsStr, dateParams)
 	case "<>", "!=":
-		dsl = fmt.Sprintf(`{"bool": {"must_not": {"term": {"%v": "%v"}}}}`, lhsStr, rhsStr)
+		dsl = e.mustNot(lhsStr, fmt.Sprintf(`{"term": {"%v": %v}}`, exactStr, rhsStr))
 	case "in":
-		rhsStr = strings.Replace(rhsStr, `'`, `"`, -1)
-		rhsStr = strings.Trim(rhsStr, "(")
-		rhsStr = strings.Trim(rhsStr, ")")
-		dsl = fmt.Sprintf(`{"terms": {"%v": [%v]}}`, lhsStr, rhsStr)
+		dsl = fmt.Sprintf(`{"terms": {"%v": [%v]}}`, exactStr, rhsStr)
 	case "not in":
-		rhsStr = strings.Replace(rhsStr, `'`, `"`, -1)
-		rhsStr = strings.Trim(rhsStr, "(")
-		rhsStr = strings.Trim(rhsStr, ")")
-		dsl = fmt.Sprintf(`{"bool": {"must_not": {"terms": {"%v": [%v]}}}}`, lhsStr, rhsStr)
-	case "like":
-		rhsStr = strings.Replace(rhsStr, `_`, `?`, -1)
-		rhsStr = strings.Replace(rhsStr, `%`, `*`, -1)
-		dsl = fmt.Sprintf(`{"wildcard": {"%v": {"wildcard": "%v"}}}`, lhsStr, rhsStr)
-	case "not like":
-		rhsStr = strings.Replace(rhsStr, `_`, `?`, -1)
-		rhsStr = strings.Replace(rhsStr, `%`, `*`, -1)
-		dsl = fmt.Sprintf(`{"bool": {"must_not": {"wildcard": {"%v": {"wildcard": "%v"}}}}}`, lhsStr, rhsStr)
+		dsl = e.mustNot(lhsStr, fmt.Sprintf(`{"terms": {"%v": [%v]}}`, exactStr, rhsStr))
+	case "like", "not like":
+		dsl, err = e.convertLikeExpr(comparisonExpr, exactStr, rhsLit.val, caseInsensitive)
+		if err != nil {
+			return "", err
+		}
+		if op == "not like" {
+			dsl = e.mustNot(lhsStr, dsl)
+		}
 	case "regexp":
-		dsl = fmt.Sprintf(`{"regexp": {"%v": "%v"}}`, lhsStr, rhsStr)
+		dsl = fmt.Sprintf(`{"regexp": {"%v": %v}}`, exactStr, jsonString(rhsLit.val))
 	case "not regexp":
-		dsl = fmt.Sprintf(`{"bool": {"must_not": {"regexp": {"%v": "%v"}}}}`, lhsStr, rhsStr)
+		dsl = e.mustNot(lhsStr, fmt.Sprintf(`{"regexp": {"%v": %v}}`, exactStr, jsonString(rhsLit.val)))
 	default:
-		err := fmt.Errorf(`esql: %s operator not supported in comparison clause`, comparisonExpr.Operator)
+		err := errorf(ErrUnsupported, sqlparser.String(comparisonExpr), `esql: %s operator not supported in comparison clause`, comparisonExpr.Operator)
 		return "", err
 	}
 	return dsl, nil
 }
 
+// convertDateEquality matches a date field to date literals by a range query of each literal, a literal
+// w/o time is the whole day. ok is false if lhsStr is not a date field
+func (e *ESql) convertDateEquality(lhsStr string, op string, rhsLits []literal) (dsl string, ok bool) {
+	var rangeSlice []string
+	for _, lit := range rhsLits {
+		format, ok := e.dateFieldFormat(lhsStr, lit)
+		if !ok {
+			return "", false
+		}
+		val := lit.json()
+		rangeSlice = append(rangeSlice, fmt.Sprintf(`{"range": {"%v": {"gte": %v, "lte": %v%v}}}`, lhsStr, val, val, e.dateRangeParams(format)))
+	}
+	if len(rangeSlice) == 0 {
+		return "", false
+	}
+	dsl = rangeSlice[0]
+	if len(rangeSlice) > 1 {
+		dsl = fmt.Sprintf(`{"bool": {"should": [%v]}}`, strings.Join(rangeSlice, ","))
+	}
+	switch op {
+	case "<>", "!=", "not in":
+		dsl = e.mustNot(lhsStr, dsl)
+	}
+	return dsl, true
+}
+
+// text field w/o keyword sub field is compared by match query, all the terms should match
+func (e *ESql) convertMatchExpr(lhsStr string, op string, rhsLit literal, rhsTuple []literal) (dsl string, err error) {
+	if rhsTuple == nil {
+		rhsTuple = []literal{rhsLit}
+	}
+	var matchSlice []string
+	for _, lit := range rhsTuple {
+		if lit.typ == literalNull {
+			continue
+		}
+		matchSlice = append(matchSlice, fmt.Sprintf(`{"match": {"%v": {"query": %v, "operator": "and"}}}`, lhsStr, lit.json()))
+	}
+	if len(matchSlice) == 1 {
+		dsl = matchSlice[0]
+	} else {
+		dsl = fmt.Sprintf(`{"bool": {"should": [%v]}}`, strings.Join(matchSlice, ","))
+	}
+	switch op {
+	case "<>", "!=", "not in":
+		dsl = e.mustNot(lhsStr, dsl)
+	}
+	return dsl, nil
+}
+
+// mustNot negates query on field. in strict null mode the field must exist, as a comparison w/ NULL is
+// unknown in sql, and so is its negation
+func (e *ESql) mustNot(field string, query string) string {
+	if e.strictNull {
+		return fmt.Sprintf(`{"bool": {"filter": {"exists": {"field": "%v"}}, "must_not": %v}}`, field, query)
+	}
+	return fmt.Sprintf(`{"bool": {"must_not": %v}}`, query)
+}
+
+// colName = NULL is taken as colName IS NULL
 func (e *ESql) convertValExpr(expr sqlparser.Expr, script bool) (dsl string, err error) {
 	switch expr.(type) {
 	case *sqlparser.SQLVal:
@@ -415,7 +741,7 @@ func (e *ESql) convertValExpr(expr sqlparser.Expr, script bool) (dsl string, err
 	case sqlparser.ValTuple:
 		dsl = sqlparser.String(expr)
 	default:
-		err = fmt.Errorf("esql: not supported rhs expression %T", expr)
+		err = errorf(ErrUnsupported, sqlparser.String(expr), "esql: not supported rhs expression %T", expr)
 		return "", err
 	}
 	return dsl, nil
@@ -424,6 +750,10 @@ func (e *ESql) convertValExpr(expr sqlparser.Expr, script bool) (dsl string, err
 func (e *ESql) convertColName(colName *sqlparser.ColName) (string, error) {
 	// here we garuantee colName is of type *ColName
 	colNameStr := sqlparser.String(colName)
+	// a column qualified by the alias of the table is the column itself
+	if _, exist := e.tableAliases[colName.Qualifier.Name.String()]; exist && colName.Qualifier.Qualifier.IsEmpty() {
+		colNameStr = sqlparser.String(colName.Name)
+	}
 	replacedColNameStr, err := e.keyProcess(colNameStr)
 	if err != nil {
 		return "", err
@@ -434,22 +764,22 @@ func (e *ESql) convertColName(colName *sqlparser.ColName) (string, error) {
 
 func (e *ESql) keyProcess(target string) (string, error) {
 	if e.filterKey != nil && e.filterKey(target) && e.processKey != nil {
-		target, err := e.processKey(target)
+		processed, err := e.processKey(target)
 		if err != nil {
-			return "", err
+			return "", wrapError(ErrMacro, target, err)
 		}
-		return target, nil
+		return processed, nil
 	}
 	return target, nil
 }
 
 func (e *ESql) valueProcess(colName string, value string) (string, error) {
 	if e.filterValue != nil && e.filterValue(colName) && e.processValue != nil {
-		value, err := e.processValue(value)
+		processed, err := e.processValue(value)
 		if err != nil {
-			return "", err
+			return "", wrapError(ErrMacro, value, err)
 		}
-		return value, nil
+		return processed, nil
 	}
 	return value, nil
 }
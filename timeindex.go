@@ -0,0 +1,278 @@
+package esql
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// TimeIndex ...
+// TimeIndex describes indices that hold the documents of a period each, e.g. daily indices events-2019.06.28
+// matched by the pattern events-*. the period is the smallest unit in Layout, hour, day, month or year
+type TimeIndex struct {
+	Pattern    string // the index pattern FROM resolves to, e.g. events-*
+	Layout     string // go time layout of the index names in UTC, e.g. events-2006.01.02
+	TimeColumn string // the column the documents are put into the indices by, e.g. @timestamp
+	MaxIndices int    // the most indices to search, DefaultMaxTimeIndices if not positive
+}
+
+// DefaultMaxTimeIndices is the most indices a time range is pruned to if TimeIndex.MaxIndices is not set
+const DefaultMaxTimeIndices = 100
+
+var timeOffsetRegexp = regexp.MustCompile(`^([+-])(\d{2}):?(\d{2})?$`)
+
+var dateMathOpRegexp = regexp.MustCompile(`^(?:([+-])(\d+)([yMwdhms])|/([yMwdhms]))`)
+
+// timeLocation returns the location of a time zone in SetTimeZone format, UTC if empty
+func timeLocation(timeZone string) (*time.Location, bool) {
+	if timeZone == "" {
+		return time.UTC, true
+	}
+	if match := timeOffsetRegexp.FindStringSubmatch(timeZone); match != nil {
+		hours, _ := strconv.Atoi(match[2])
+		minutes, _ := strconv.Atoi(match[3])
+		offset := hours*3600 + minutes*60
+		if match[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(timeZone, offset), true
+	}
+	loc, err := time.LoadLocation(timeZone)
+	return loc, err == nil
+}
+
+// truncateTime rounds t down to the start of unit in es date math in the location of t
+func truncateTime(t time.Time, unit string) time.Time {
+	year, month, day := t.Date()
+	switch unit {
+	case "y":
+		return time.Date(year, 1, 1, 0, 0, 0, 0, t.Location())
+	case "M":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case "w":
+		// weeks start on monday
+		weekday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-weekday, 0, 0, 0, 0, t.Location())
+	case "d":
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case "h":
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case "m":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, t.Location())
+	default:
+		return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	}
+}
+
+// addTime adds n units in es date math to t
+func addTime(t time.Time, n int, unit string) time.Time {
+	switch unit {
+	case "y":
+		return t.AddDate(n, 0, 0)
+	case "M":
+		return t.AddDate(0, n, 0)
+	case "w":
+		return t.AddDate(0, 0, 7*n)
+	case "d":
+		return t.AddDate(0, 0, n)
+	case "h":
+		return t.Add(time.Duration(n) * time.Hour)
+	case "m":
+		return t.Add(time.Duration(n) * time.Minute)
+	default:
+		return t.Add(time.Duration(n) * time.Second)
+	}
+}
+
+// evalDateMath evaluates date math from convertDateMath at now, e.g. now-7d/d. as es does for lte and gt,
+// roundUp rounds to the end of the unit rather than its start
+func evalDateMath(dateMath string, now time.Time, roundUp bool) (time.Time, bool) {
+	if !strings.HasPrefix(dateMath, "now") {
+		return time.Time{}, false
+	}
+	t := now
+	for rest := dateMath[len("now"):]; rest != ""; {
+		match := dateMathOpRegexp.FindStringSubmatch(rest)
+		if match == nil {
+			return time.Time{}, false
+		}
+		rest = rest[len(match[0]):]
+		if match[4] != "" {
+			t = truncateTime(t, match[4])
+			if roundUp {
+				t = addTime(t, 1, match[4]).Add(-time.Millisecond)
+			}
+			continue
+		}
+		n, err := strconv.Atoi(match[2])
+		if err != nil {
+			return time.Time{}, false
+		}
+		if match[1] == "-" {
+			n = -n
+		}
+		t = addTime(t, n, match[3])
+	}
+	return t, true
+}
+
+// evalTimeBound evaluates a bound of a comparison on colNameStr, a date literal, epoch millis or an
+// expression in date math. ok is false if it can not be evaluated before the query runs
+func (e *ESql) evalTimeBound(colNameStr string, expr sqlparser.Expr, roundUp bool) (t time.Time, ok bool) {
+	loc, ok := timeLocation(e.timeZone)
+	if !ok {
+		return time.Time{}, false
+	}
+	if dateMath, isDateMath := e.convertDateMath(expr); isDateMath {
+		return evalDateMath(dateMath, timeNow().In(loc), roundUp)
+	}
+	lit, ok := convertLiteral(expr)
+	if !ok {
+		return time.Time{}, false
+	}
+	lit, err := e.processLiteral(colNameStr, lit)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch lit.typ {
+	case literalInt:
+		millis, err := strconv.ParseInt(lit.val, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(0, millis*int64(time.Millisecond)), true
+	case literalString:
+		for _, f := range dateLiteralFormats {
+			if t, err := time.ParseInLocation(f.layout, lit.val, loc); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// timeRange returns the range of column the top level conjuncts of expr restrict it to, a bound is zero
+// if it is open-ended. bounds are inclusive, as the indices a bound is in must be searched either way
+func (e *ESql) timeRange(expr sqlparser.Expr, column string) (from time.Time, to time.Time) {
+	if expr == nil {
+		return from, to
+	}
+	narrow := func(colExpr sqlparser.Expr, lower sqlparser.Expr, upper sqlparser.Expr) {
+		colName, ok := colExpr.(*sqlparser.ColName)
+		if !ok {
+			return
+		}
+		colNameStr, err := e.convertColName(colName)
+		if err != nil || colNameStr != column {
+			return
+		}
+		if lower != nil {
+			if t, ok := e.evalTimeBound(colNameStr, lower, false); ok && (from.IsZero() || t.After(from)) {
+				from = t
+			}
+		}
+		if upper != nil {
+			if t, ok := e.evalTimeBound(colNameStr, upper, true); ok && (to.IsZero() || t.Before(to)) {
+				to = t
+			}
+		}
+	}
+	for _, conjunct := range flattenAnd(expr) {
+		switch conjunct := conjunct.(type) {
+		case *sqlparser.ComparisonExpr:
+			switch conjunct.Operator {
+			case sqlparser.EqualStr:
+				narrow(conjunct.Left, conjunct.Right, conjunct.Right)
+			case sqlparser.GreaterThanStr, sqlparser.GreaterEqualStr:
+				narrow(conjunct.Left, conjunct.Right, nil)
+			case sqlparser.LessThanStr, sqlparser.LessEqualStr:
+				narrow(conjunct.Left, nil, conjunct.Right)
+			}
+		case *sqlparser.RangeCond:
+			if conjunct.Operator != sqlparser.NotBetweenStr {
+				narrow(conjunct.Left, conjunct.From, conjunct.To)
+			}
+		}
+	}
+	return from, to
+}
+
+// timeIndexUnit returns the period of an index of layout in es date math, the smallest unit in layout
+func timeIndexUnit(layout string) string {
+	switch {
+	case strings.Contains(layout, "15"):
+		return "h"
+	case strings.Contains(layout, "02") || strings.Contains(layout, "_2"):
+		return "d"
+	case strings.Contains(layout, "01") || strings.Contains(layout, "Jan"):
+		return "M"
+	default:
+		return "y"
+	}
+}
+
+// timeIndexNames returns the names of the indices of timeIndex from the one from is in to the one to is
+// in, ok is false if there are more than max of them
+func timeIndexNames(timeIndex TimeIndex, from time.Time, to time.Time, max int) (names []string, ok bool) {
+	unit := timeIndexUnit(timeIndex.Layout)
+	to = to.UTC()
+	for t := truncateTime(from.UTC(), unit); !t.After(to); t = addTime(t, 1, unit) {
+		if len(names) == max {
+			return nil, false
+		}
+		names = append(names, t.Format(timeIndex.Layout))
+	}
+	return names, true
+}
+
+func (e *ESql) lookupTimeIndex(pattern string) (TimeIndex, bool) {
+	for _, timeIndex := range e.timeIndices {
+		if timeIndex.Pattern == pattern {
+			return timeIndex, true
+		}
+	}
+	return TimeIndex{}, false
+}
+
+// pruneTimeIndices replaces the patterns of time indices FROM resolves to by the indices the time range
+// of expr can be in. a range w/o lower bound can be in any index, a range w/o upper bound ends now. a
+// pattern is kept if the range has no lower bound, more than MaxIndices indices or none. pruned is false
+// if no pattern is replaced
+func (e *ESql) pruneTimeIndices(expr sqlparser.Expr) (indices []string, pruned bool) {
+	if len(e.timeIndices) == 0 {
+		return nil, false
+	}
+	for _, index := range e.indices {
+		timeIndex, exist := e.lookupTimeIndex(index)
+		if !exist {
+			indices = append(indices, index)
+			continue
+		}
+		max := timeIndex.MaxIndices
+		if max <= 0 {
+			max = DefaultMaxTimeIndices
+		}
+		from, to := e.timeRange(expr, timeIndex.TimeColumn)
+		if from.IsZero() {
+			indices = append(indices, index)
+			continue
+		}
+		if to.IsZero() {
+			to = timeNow()
+		}
+		names, ok := timeIndexNames(timeIndex, from, to, max)
+		if !ok || len(names) == 0 {
+			indices = append(indices, index)
+			continue
+		}
+		indices = append(indices, names...)
+		pruned = true
+	}
+	if !pruned {
+		return nil, false
+	}
+	return indices, true
+}
@@ -0,0 +1,342 @@
+package esql
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// UnionRequest ...
+// UnionRequest is a UNION ALL converted from sql, its branches are searched together by an _msearch
+// and their rows are merged by the ORDER BY and LIMIT of the union
+type UnionRequest struct {
+	Branches []*SearchRequest
+	OrderBy  []UnionOrder
+	Offset   int
+	Limit    int // -1 if the union has no LIMIT
+}
+
+// UnionOrder is an item of the ORDER BY of a union, a column of the rows
+type UnionOrder struct {
+	Column string
+	Desc   bool
+}
+
+// ConvertUnion ...
+// Transform a UNION ALL sql to an elasticsearch multi search request
+//
+// usage:
+//  - request, err := e.ConvertUnion(sql)
+//
+// arguments:
+//  - sql: the sql query needs conversion in string format, SELECT ... UNION ALL SELECT ... [ORDER BY ...] [LIMIT ...]
+//
+// return values:
+//  - request: a search request per branch, each branch is a select converted as ConvertRequest does. the ORDER BY
+//    of the union sorts each branch as well, and its LIMIT bounds the size of each branch by offset + count
+//  - err: contains err information
+func (e *ESql) ConvertUnion(sql string) (request *UnionRequest, err error) {
+	conv, stmt, err := e.prepare(sql)
+	if err != nil {
+		return nil, locateError(sql, err)
+	}
+	request, err = conv.convertUnion(stmt)
+	if err != nil {
+		return nil, locateError(sql, err)
+	}
+	return request, nil
+}
+
+func (e *ESql) convertUnion(stmt sqlparser.Statement) (request *UnionRequest, err error) {
+	request = &UnionRequest{Limit: -1}
+	var branches []*sqlparser.Select
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		// a single select is a union of itself
+		branches = []*sqlparser.Select{stmt}
+	case *sqlparser.Union:
+		if len(stmt.OrderBy) > 0 || stmt.Limit != nil {
+			// the ORDER BY and LIMIT of the union are not of its last branch
+			outer := *stmt
+			outer.OrderBy, outer.Limit = nil, nil
+			branches, err = unionBranches(&outer)
+		} else {
+			branches, err = unionBranches(stmt)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = e.setUnionOrder(request, stmt.OrderBy, stmt.Limit); err != nil {
+			return nil, err
+		}
+		pushDownUnionOrder(branches, stmt.OrderBy, request)
+	default:
+		err = errorf(ErrUnsupported, "", `esql: Queries other than select not supported`)
+		return nil, err
+	}
+	if err = checkUnionColumns(branches); err != nil {
+		return nil, err
+	}
+	for _, sel := range branches {
+		// every branch has its own indices and request level parameters
+		branch := *e
+		searchRequest, err := branch.convertRequest(*sel)
+		if err != nil {
+			return nil, err
+		}
+		// the rows of a branch are its hits or its GROUP BY buckets, aggregations w/o GROUP BY have neither
+		if searchRequest.ungrouped {
+			err = errorf(ErrUnsupported, sqlparser.String(sel.SelectExprs), `esql: aggregation w/o GROUP BY in UNION not supported`)
+			return nil, err
+		}
+		request.Branches = append(request.Branches, searchRequest)
+	}
+	return request, nil
+}
+
+// checkUnionColumns checks that the branches select the same number of columns, a branch selecting * may
+// have any columns
+func checkUnionColumns(branches []*sqlparser.Select) error {
+	columns := -1
+	for _, sel := range branches {
+		star := false
+		for _, selectExpr := range sel.SelectExprs {
+			if _, ok := selectExpr.(*sqlparser.StarExpr); ok {
+				star = true
+			}
+		}
+		if star {
+			continue
+		}
+		if columns >= 0 && len(sel.SelectExprs) != columns {
+			err := errorf(ErrSyntax, sqlparser.String(sel.SelectExprs), `esql: each select of UNION must have the same number of columns, %v != %v`, len(sel.SelectExprs), columns)
+			return err
+		}
+		columns = len(sel.SelectExprs)
+	}
+	return nil
+}
+
+func checkUnionAll(union *sqlparser.Union) error {
+	if union.Type != sqlparser.UnionAllStr {
+		return errorf(ErrUnsupported, "UNION", `esql: %v not supported, rows can not be deduplicated across indices`, strings.ToUpper(union.Type))
+	}
+	return nil
+}
+
+// unionBranches returns the selects of a UNION ALL from left to right, a union in parentheses can not have
+// its own ORDER BY or LIMIT
+func unionBranches(stmt sqlparser.SelectStatement) ([]*sqlparser.Select, error) {
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		return []*sqlparser.Select{stmt}, nil
+	case *sqlparser.ParenSelect:
+		return unionBranches(stmt.Select)
+	case *sqlparser.Union:
+		if err := checkUnionAll(stmt); err != nil {
+			return nil, err
+		}
+		if len(stmt.OrderBy) > 0 || stmt.Limit != nil {
+			err := errorf(ErrUnsupported, sqlparser.String(stmt), `esql: ORDER BY or LIMIT of an inner UNION not supported`)
+			return nil, err
+		}
+		lhs, err := unionBranches(stmt.Left)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := unionBranches(stmt.Right)
+		if err != nil {
+			return nil, err
+		}
+		return append(lhs, rhs...), nil
+	}
+	err := errorf(ErrUnsupported, sqlparser.String(stmt), `esql: %T in UNION not supported`, stmt)
+	return nil, err
+}
+
+// setUnionOrder sets the ORDER BY and LIMIT of request from the union, ORDER BY must be on columns
+func (e *ESql) setUnionOrder(request *UnionRequest, orderBy sqlparser.OrderBy, limit *sqlparser.Limit) error {
+	for _, orderExpr := range orderBy {
+		colName, ok := orderExpr.Expr.(*sqlparser.ColName)
+		if !ok {
+			err := errorf(ErrUnsupported, sqlparser.String(orderExpr), `esql: ORDER BY of UNION only supports columns`)
+			return err
+		}
+		colNameStr, err := e.convertColName(colName)
+		if err != nil {
+			return err
+		}
+		order := UnionOrder{Column: strings.Trim(colNameStr, "`"), Desc: orderExpr.Direction == sqlparser.DescScr}
+		request.OrderBy = append(request.OrderBy, order)
+	}
+	if limit == nil {
+		return nil
+	}
+	var err error
+	if limit.Offset != nil {
+		if request.Offset, err = unionLimitValue(limit.Offset); err != nil {
+			return err
+		}
+	}
+	request.Limit, err = unionLimitValue(limit.Rowcount)
+	return err
+}
+
+func unionLimitValue(expr sqlparser.Expr) (int, error) {
+	if val, ok := expr.(*sqlparser.SQLVal); ok && val.Type == sqlparser.IntVal {
+		if n, err := strconv.Atoi(string(val.Val)); err == nil {
+			return n, nil
+		}
+	}
+	return 0, errorf(ErrSyntax, sqlparser.String(expr), `esql: LIMIT of UNION must be an integer`)
+}
+
+// pushDownUnionOrder sorts the branches w/o LIMIT by the ORDER BY of the union, and limits them to the
+// rows the LIMIT of the union can take from them. a branch w/ LIMIT keeps its rows, and is sorted by the
+// union if it has no ORDER BY
+func pushDownUnionOrder(branches []*sqlparser.Select, orderBy sqlparser.OrderBy, request *UnionRequest) {
+	for _, sel := range branches {
+		if sel.Limit != nil {
+			if len(sel.OrderBy) == 0 {
+				sel.OrderBy = orderBy
+			}
+			continue
+		}
+		if len(orderBy) > 0 {
+			sel.OrderBy = orderBy
+		}
+		if request.Limit >= 0 {
+			rowcount := sqlparser.NewIntVal([]byte(strconv.Itoa(request.Offset + request.Limit)))
+			sel.Limit = &sqlparser.Limit{Rowcount: rowcount}
+		}
+	}
+}
+
+// Path returns the url path of the _msearch of the union
+func (r *UnionRequest) Path() string {
+	return "/_msearch"
+}
+
+// Body returns the NDJSON body of the _msearch of the union
+func (r *UnionRequest) Body() (string, error) {
+	return msearchBody(r.Branches)
+}
+
+// Decode ...
+// Flatten the _msearch response of the union into rows
+//
+// usage:
+//  - rows, err := request.Decode(response)
+//
+// arguments:
+//  - response: the raw json response elasticsearch returns for the _msearch
+//
+// return values:
+//  - rows: the rows of all branches as DecodeHits or DecodeGroupBy returns them, in the order of the
+//    branches, then sorted by the ORDER BY of the union and cut by its LIMIT
+//  - err: contains err information, a branch that fails fails the union
+func (r *UnionRequest) Decode(response []byte) (rows []map[string]interface{}, err error) {
+	responses, errs, err := decodeMultiSearch(response)
+	if err != nil {
+		return nil, err
+	}
+	if len(responses) != len(r.Branches) {
+		err = errorf(ErrResponse, "", `esql: %v responses for %v branches of UNION`, len(responses), len(r.Branches))
+		return nil, err
+	}
+	for i, raw := range responses {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		branchRows, err := decodeRows(raw)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, branchRows...)
+	}
+	if len(r.OrderBy) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			return r.less(rows[i], rows[j])
+		})
+	}
+	if r.Offset >= len(rows) {
+		return nil, nil
+	}
+	rows = rows[r.Offset:]
+	if r.Limit >= 0 && r.Limit < len(rows) {
+		rows = rows[:r.Limit]
+	}
+	return rows, nil
+}
+
+// less compares rows by the ORDER BY of the union. as es sorts, missing values are the last in both orders
+func (r *UnionRequest) less(lhs map[string]interface{}, rhs map[string]interface{}) bool {
+	for _, order := range r.OrderBy {
+		lhsVal, rhsVal := rowValue(lhs, order.Column), rowValue(rhs, order.Column)
+		switch {
+		case lhsVal == nil && rhsVal == nil:
+			continue
+		case lhsVal == nil:
+			return false
+		case rhsVal == nil:
+			return true
+		}
+		cmp := compareValues(lhsVal, rhsVal)
+		if cmp == 0 {
+			continue
+		}
+		return (cmp < 0) != order.Desc
+	}
+	return false
+}
+
+// rowValue returns the value of column in row, a column of an object field is looked up in the object
+// as _source keeps it
+func rowValue(row map[string]interface{}, column string) interface{} {
+	if v, exist := row[column]; exist {
+		return v
+	}
+	parts := strings.SplitN(column, ".", 2)
+	if len(parts) < 2 {
+		return nil
+	}
+	object, ok := row[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return rowValue(object, parts[1])
+}
+
+// compareValues compares json values of the same type, values of different types are compared as strings
+func compareValues(lhs interface{}, rhs interface{}) int {
+	switch lhs := lhs.(type) {
+	case float64:
+		if rhs, ok := rhs.(float64); ok {
+			switch {
+			case lhs < rhs:
+				return -1
+			case lhs > rhs:
+				return 1
+			}
+			return 0
+		}
+	case string:
+		if rhs, ok := rhs.(string); ok {
+			return strings.Compare(lhs, rhs)
+		}
+	case bool:
+		if rhs, ok := rhs.(bool); ok {
+			switch {
+			case lhs == rhs:
+				return 0
+			case rhs:
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(fmt.Sprint(lhs), fmt.Sprint(rhs))
+}
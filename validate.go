@@ -0,0 +1,184 @@
+package esql
+
+import (
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// ValidationError ...
+// ValidationError is a problem Validate finds in a query, Column is empty if no column is involved.
+// Code, Fragment and Position are the same as those of Error
+type ValidationError struct {
+	Column   string
+	Message  string
+	Code     ErrorCode
+	Fragment string
+	Position int
+}
+
+func (err ValidationError) Error() string {
+	return err.Message
+}
+
+// ValidationErrors ...
+// ValidationErrors is all the problems Validate finds in a query
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	var msgSlice []string
+	for _, err := range errs {
+		msgSlice = append(msgSlice, err.Message)
+	}
+	return strings.Join(msgSlice, "; ")
+}
+
+func (errs *ValidationErrors) add(column string, err error) {
+	validationErr := ValidationError{Column: column, Message: err.Error(), Position: -1}
+	if esqlErr, ok := err.(*Error); ok {
+		validationErr.Message = esqlErr.Message
+		validationErr.Code = esqlErr.Code
+		validationErr.Fragment = esqlErr.Fragment
+		validationErr.Position = esqlErr.Position
+	}
+	for _, existing := range *errs {
+		if existing.Message == validationErr.Message {
+			return
+		}
+	}
+	*errs = append(*errs, validationErr)
+}
+
+// locate fills the positions of the fragments in sql
+func (errs ValidationErrors) locate(sql string) {
+	for i := range errs {
+		errs[i].Position = locatePosition(sql, errs[i].Fragment, errs[i].Position)
+	}
+}
+
+// Validate ...
+// Check sql against the schema and settings without producing dsl
+//
+// usage:
+//  - err := e.Validate(sql)
+//
+// arguments:
+//  - sql: the sql query needs validation in string format
+//
+// return values:
+//  - err: nil if sql can be converted, otherwise ValidationErrors that contains all the problems found,
+//    i.e. unknown columns, type mismatches and unsupported constructs. columns are only checked if a schema is set
+func (e *ESql) Validate(sql string) error {
+	var errs ValidationErrors
+	conv, stmt, err := e.prepare(sql)
+	if err != nil {
+		errs.add("", err)
+		errs.locate(sql)
+		return errs
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		errs.add("", errorf(ErrUnsupported, "", `esql: Queries other than select not supported`))
+		return errs
+	}
+	if err := conv.setIndex(*sel); err != nil {
+		errs.add("", err)
+		errs.locate(sql)
+		return errs
+	}
+	conv.validateColumns(sel, &errs)
+	// unsupported constructs are found by converting, only the first one is reported
+	if _, _, err := conv.convertSelect(*sel, ""); err != nil {
+		errs.add("", err)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	errs.locate(sql)
+	return errs
+}
+
+// validateColumns checks columns against the schema: unknown columns, literals of wrong types,
+// and operators and aggregations the field types do not support
+func (e *ESql) validateColumns(sel *sqlparser.Select, errs *ValidationErrors) {
+	if e.schema == nil {
+		return
+	}
+	aliases := make(map[string]int)
+	for _, selectExpr := range sel.SelectExprs {
+		if aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr); ok && !aliasedExpr.As.IsEmpty() {
+			aliases[aliasedExpr.As.String()] = 1
+		}
+	}
+	colNameStr := func(colName *sqlparser.ColName) string {
+		str, err := e.convertColName(colName)
+		if err != nil {
+			errs.add(sqlparser.String(colName), err)
+			return ""
+		}
+		return str
+	}
+
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.ColName:
+			col := colNameStr(node)
+			_, isAlias := aliases[col]
+			// meta fields like _id are not in mappings
+			if col == "" || isAlias || strings.HasPrefix(col, "_") {
+				break
+			}
+			if _, exist := e.fieldMapping(col); !exist {
+				errs.add(col, errorf(ErrPolicy, col, `esql: unknown column %v in %v`, col, e.index))
+			}
+		case *sqlparser.ComparisonExpr:
+			colName, ok := node.Left.(*sqlparser.ColName)
+			if !ok {
+				break
+			}
+			col := colNameStr(colName)
+			mapping, exist := e.fieldMapping(col)
+			if !exist {
+				break
+			}
+			switch node.Operator {
+			case sqlparser.LikeStr, sqlparser.NotLikeStr:
+				if mapping.Type != "keyword" && mapping.Type != "text" {
+					errs.add(col, errorf(ErrPolicy, sqlparser.String(node), `esql: LIKE on %v field %v not supported`, mapping.Type, col))
+				}
+			case sqlparser.RegexpStr, sqlparser.NotRegexpStr:
+				if _, exact := e.keywordField(col); !exact {
+					errs.add(col, errorf(ErrPolicy, sqlparser.String(node), `esql: REGEXP on text field %v w/o keyword sub field matches analyzed terms rather than the text`, col))
+				}
+			default:
+				if lit, ok := convertLiteral(node.Right); ok {
+					if _, err := e.coerceLiteral(col, lit); err != nil {
+						errs.add(col, err)
+					}
+				}
+			}
+		case *sqlparser.FuncExpr:
+			funcName := strings.ToLower(node.Name.String())
+			isAgg := funcName == "count" || funcName == "avg" || funcName == "sum" || funcName == "min" || funcName == "max"
+			if !isAgg || len(node.Exprs) != 1 {
+				break
+			}
+			aliasedExpr, ok := node.Exprs[0].(*sqlparser.AliasedExpr)
+			if !ok {
+				break
+			}
+			colName, ok := aliasedExpr.Expr.(*sqlparser.ColName)
+			if !ok {
+				break
+			}
+			col := colNameStr(colName)
+			if funcName == "count" && node.Distinct {
+				funcName = "cardinality"
+			}
+			if _, err := e.aggField(funcName, col); err != nil {
+				errs.add(col, err)
+			}
+		}
+		return true, nil
+	}, sel)
+}
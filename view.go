@@ -0,0 +1,261 @@
+package esql
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+var withRegexp = regexp.MustCompile(`(?i)^\s*WITH\s+`)
+var cteNameRegexp = regexp.MustCompile(`(?i)^([a-z_][a-z0-9_]*)\s+AS\s*\(`)
+
+// extractCTEs splits WITH name AS (select), ... select into the CTEs by name and the select, sqlparser
+// does not understand WITH
+func extractCTEs(sql string) (ctes map[string]string, rest string, err error) {
+	loc := withRegexp.FindStringIndex(sql)
+	if loc == nil {
+		return nil, sql, nil
+	}
+	masked := maskQuoted(sql)
+	ctes = make(map[string]string)
+	for i := loc[1]; ; {
+		match := cteNameRegexp.FindStringSubmatchIndex(masked[i:])
+		if match == nil {
+			err = errorf(ErrSyntax, sql[i:], `esql: WITH requires name AS (select)`)
+			return nil, "", err
+		}
+		name := sql[i+match[2] : i+match[3]]
+		open := i + match[1] - 1
+		end := matchParen(masked, open)
+		if end < 0 {
+			err = errorf(ErrSyntax, sql[open:], `esql: unbalanced parenthesis in WITH`)
+			return nil, "", err
+		}
+		if _, exist := ctes[name]; exist {
+			err = errorf(ErrSyntax, name, `esql: CTE %v is defined more than once`, name)
+			return nil, "", err
+		}
+		ctes[name] = sql[open+1 : end]
+		i = skipSpaces(masked, end+1)
+		if i >= len(masked) || masked[i] != ',' {
+			return ctes, sql[i:], nil
+		}
+		i = skipSpaces(masked, i+1)
+	}
+}
+
+// parseView parses the select of a CTE or a view, which is preprocessed already
+func parseView(name string, sql string) (*sqlparser.Select, error) {
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, wrapParseError(err)
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		err = errorf(ErrUnsupported, name, `esql: CTE or view %v must be a select`, name)
+		return nil, err
+	}
+	return sel, nil
+}
+
+// expandViews replaces the CTEs and views in FROM of stmt by the selects they stand for
+func (e *ESql) expandViews(stmt sqlparser.Statement, ctes map[string]string) (sqlparser.Statement, error) {
+	if len(ctes) == 0 && len(e.views) == 0 {
+		return stmt, nil
+	}
+	if selectStmt, ok := stmt.(sqlparser.SelectStatement); ok {
+		return e.expandSelectStatement(selectStmt, ctes, nil)
+	}
+	return stmt, nil
+}
+
+// expandSelectStatement expands the views in each select of stmt. a CTE or view being expanded is an
+// index in its own select, so a view may filter the index of the same name
+func (e *ESql) expandSelectStatement(stmt sqlparser.SelectStatement, ctes map[string]string, expanding map[string]int) (sqlparser.SelectStatement, error) {
+	var err error
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select:
+		return e.expandSelect(stmt, ctes, expanding)
+	case *sqlparser.ParenSelect:
+		stmt.Select, err = e.expandSelectStatement(stmt.Select, ctes, expanding)
+	case *sqlparser.Union:
+		if stmt.Left, err = e.expandSelectStatement(stmt.Left, ctes, expanding); err != nil {
+			return nil, err
+		}
+		stmt.Right, err = e.expandSelectStatement(stmt.Right, ctes, expanding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// lookupView returns the select name stands for, CTEs of the query take precedence over views
+func (e *ESql) lookupView(name string, ctes map[string]string, expanding map[string]int) (sql string, exist bool) {
+	if _, exist = expanding[name]; exist {
+		return "", false
+	}
+	if sql, exist = ctes[name]; exist {
+		return sql, true
+	}
+	sql, exist = e.views[name]
+	return sql, exist
+}
+
+func (e *ESql) expandSelect(sel *sqlparser.Select, ctes map[string]string, expanding map[string]int) (*sqlparser.Select, error) {
+	var name, viewSQL string
+	for _, tableExpr := range sel.From {
+		aliasedTableExpr, ok := tableExpr.(*sqlparser.AliasedTableExpr)
+		if !ok {
+			continue
+		}
+		tableName, ok := aliasedTableExpr.Expr.(sqlparser.TableName)
+		if !ok {
+			continue
+		}
+		table := strings.Replace(sqlparser.String(tableName), "`", "", -1)
+		sql, exist := e.lookupView(table, ctes, expanding)
+		if !exist {
+			continue
+		}
+		if len(sel.From) != 1 {
+			err := errorf(ErrUnsupported, table, `esql: CTE or view %v must be the only table in FROM`, table)
+			return nil, err
+		}
+		name, viewSQL = table, sql
+	}
+	if name == "" {
+		return sel, nil
+	}
+	view, err := parseView(name, viewSQL)
+	if err != nil {
+		return nil, err
+	}
+	nested := map[string]int{name: 1}
+	for expandingName := range expanding {
+		nested[expandingName] = 1
+	}
+	view, err = e.expandSelect(view, ctes, nested)
+	if err != nil {
+		return nil, err
+	}
+	return mergeView(name, sel, view)
+}
+
+// mergeView merges view, a filter and projection of a single index, into sel that selects from it.
+// WHERE of both are in AND, and the columns of sel are those the view selects them as
+func mergeView(name string, sel *sqlparser.Select, view *sqlparser.Select) (*sqlparser.Select, error) {
+	if len(view.GroupBy) > 0 || view.Having != nil || len(view.OrderBy) > 0 || view.Limit != nil || view.Distinct != "" || len(view.From) != 1 {
+		err := errorf(ErrUnsupported, name, `esql: CTE or view %v must be a filter and projection of a single index`, name)
+		return nil, err
+	}
+	viewTable, ok := view.From[0].(*sqlparser.AliasedTableExpr)
+	if !ok {
+		err := errorf(ErrUnsupported, name, `esql: CTE or view %v must be a filter and projection of a single index`, name)
+		return nil, err
+	}
+	// columns qualified by the table of the view or of sel are the columns themselves
+	unqualify := func(colName *sqlparser.ColName, aliases ...string) {
+		if !colName.Qualifier.Qualifier.IsEmpty() {
+			return
+		}
+		for _, alias := range aliases {
+			if alias != "" && colName.Qualifier.Name.String() == alias {
+				colName.Qualifier = sqlparser.TableName{}
+			}
+		}
+	}
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if colName, ok := node.(*sqlparser.ColName); ok {
+			unqualify(colName, viewTable.As.String())
+		}
+		return true, nil
+	}, view.SelectExprs, view.Where)
+
+	// the columns the view selects by the names they are selected as, nil if it selects *
+	projection := make(map[string]*sqlparser.ColName)
+	star := false
+	for _, selectExpr := range view.SelectExprs {
+		switch selectExpr := selectExpr.(type) {
+		case *sqlparser.StarExpr:
+			star = true
+		case *sqlparser.AliasedExpr:
+			colName, ok := selectExpr.Expr.(*sqlparser.ColName)
+			if !ok {
+				err := errorf(ErrUnsupported, sqlparser.String(selectExpr), `esql: CTE or view %v can only select columns`, name)
+				return nil, err
+			}
+			key := selectExpr.As.String()
+			if key == "" {
+				key = viewColumnKey(colName)
+			}
+			projection[key] = colName
+		}
+	}
+	if star {
+		projection = nil
+	}
+
+	outerAlias := sel.From[0].(*sqlparser.AliasedTableExpr).As.String()
+	selectAliases := make(map[string]int)
+	for _, selectExpr := range sel.SelectExprs {
+		if aliasedExpr, ok := selectExpr.(*sqlparser.AliasedExpr); ok && !aliasedExpr.As.IsEmpty() {
+			selectAliases[aliasedExpr.As.String()] = 1
+		}
+	}
+	// aliases of sel stand for its own select expressions in HAVING and ORDER BY only, elsewhere a name is
+	// a column of the view
+	rewrite := func(aliases map[string]int) func(node sqlparser.SQLNode) (bool, error) {
+		return func(node sqlparser.SQLNode) (bool, error) {
+			colName, ok := node.(*sqlparser.ColName)
+			if !ok {
+				return true, nil
+			}
+			unqualify(colName, name, outerAlias)
+			key := viewColumnKey(colName)
+			if _, exist := aliases[key]; exist || projection == nil {
+				return false, nil
+			}
+			source, exist := projection[key]
+			if !exist {
+				err := errorf(ErrSyntax, key, `esql: column %v is not selected by CTE or view %v`, key, name)
+				return false, err
+			}
+			*colName = *source
+			return false, nil
+		}
+	}
+	if err := sqlparser.Walk(rewrite(nil), sel.SelectExprs, sel.Where, sel.GroupBy); err != nil {
+		return nil, err
+	}
+	if err := sqlparser.Walk(rewrite(selectAliases), sel.Having, sel.OrderBy); err != nil {
+		return nil, err
+	}
+	if projection != nil {
+		var selectExprs sqlparser.SelectExprs
+		for _, selectExpr := range sel.SelectExprs {
+			if _, ok := selectExpr.(*sqlparser.StarExpr); ok {
+				selectExprs = append(selectExprs, view.SelectExprs...)
+				continue
+			}
+			selectExprs = append(selectExprs, selectExpr)
+		}
+		sel.SelectExprs = selectExprs
+	}
+
+	sel.From = sqlparser.TableExprs{&sqlparser.AliasedTableExpr{Expr: viewTable.Expr, Hints: viewTable.Hints}}
+	switch {
+	case view.Where == nil:
+	case sel.Where == nil:
+		sel.Where = view.Where
+	default:
+		sel.Where = sqlparser.NewWhere(sqlparser.WhereStr, &sqlparser.AndExpr{Left: view.Where.Expr, Right: sel.Where.Expr})
+	}
+	return sel, nil
+}
+
+// viewColumnKey returns the name of a column w/o quotes, as aliases are
+func viewColumnKey(colName *sqlparser.ColName) string {
+	return strings.Replace(sqlparser.String(colName), "`", "", -1)
+}